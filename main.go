@@ -21,11 +21,11 @@
 package main
 
 import (
-	"fmt"
 	ocm_descriptor_sidecar "icos/server/ocm-descriptor-sidecar"
+	"icos/server/ocm-descriptor-sidecar/utils/logs"
 )
 
 func main() {
-	fmt.Println("Starting Sidecar Container...")
+	logs.Logger.Info("starting sidecar container")
 	ocm_descriptor_sidecar.Run()
 }
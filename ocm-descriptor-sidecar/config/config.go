@@ -0,0 +1,533 @@
+/*
+  OCM-DESCRIPTOR-SIDECAR
+  Copyright © 2022-2024 EVIDEN
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+  This work has received funding from the European Union's HORIZON research
+  and innovation programme under grant agreement No. 101070177.
+*/
+
+// Package config gathers the configuration of the sidecar, read from an optional
+// YAML file, the environment and the command line, in increasing order of precedence
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"icos/server/ocm-descriptor-sidecar/utils/breaker"
+	"icos/server/ocm-descriptor-sidecar/utils/leader"
+	"icos/server/ocm-descriptor-sidecar/utils/logs"
+	"icos/server/ocm-descriptor-sidecar/utils/oidc"
+	"icos/server/ocm-descriptor-sidecar/utils/retry"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Config is the whole configuration of the sidecar
+type Config struct {
+	DeployManager  DeployManager  `yaml:"deployManager"`
+	Auth           Auth           `yaml:"auth"`
+	Keycloak       Keycloak       `yaml:"keycloak"`
+	LighthouseURL  string         `yaml:"lighthouseURL"`
+	MatchmakingURL string         `yaml:"matchmakingURL"`
+	Server         Server         `yaml:"server"`
+	Tasks          Tasks          `yaml:"tasks"`
+	LeaderElection LeaderElection `yaml:"leaderElection"`
+	Log            Log            `yaml:"log"`
+	Tracing        Tracing        `yaml:"tracing"`
+	// WatchInterval is how often the watched files are checked for changes, 0 disables it
+	WatchInterval time.Duration `yaml:"watchInterval"`
+
+	// File is the YAML file the configuration was read from, if any
+	File string `yaml:"-"`
+}
+
+// WatchedFiles returns the files whose changes trigger a reload
+func (c *Config) WatchedFiles() []string {
+	var files []string
+	if c.File != "" {
+		files = append(files, c.File)
+	}
+	for _, credential := range c.credentials() {
+		if *credential.file != "" {
+			files = append(files, *credential.file)
+		}
+	}
+	files = append(files, c.Keycloak.ClientAuth.Files()...)
+	return append(files, c.Auth.OAuth2.ClientAuth.Files()...)
+}
+
+// credential is a setting that may be read from a file instead, e.g. a mounted
+// Secret, so that it does not show up in the environment of the process
+type credential struct {
+	name  string
+	value *string
+	file  *string
+}
+
+// credentials lists the settings that have a *_FILE variant
+func (c *Config) credentials() []credential {
+	return []credential{
+		{"keycloak.clientID (KEYCLOAK_CLIENT_ID)", &c.Keycloak.ClientID, &c.Keycloak.ClientIDFile},
+		{"keycloak.clientSecret (KEYCLOAK_CLIENT_SECRET)", &c.Keycloak.ClientSecret, &c.Keycloak.ClientSecretFile},
+		{"auth.oauth2.clientID (OAUTH2_CLIENT_ID)", &c.Auth.OAuth2.ClientID, &c.Auth.OAuth2.ClientIDFile},
+		{"auth.oauth2.clientSecret (OAUTH2_CLIENT_SECRET)", &c.Auth.OAuth2.ClientSecret, &c.Auth.OAuth2.ClientSecretFile},
+	}
+}
+
+// DeployManager configures the calls to the Deployment Manager
+type DeployManager struct {
+	URL     string           `yaml:"url"`
+	Retry   retry.Policy     `yaml:"retry"`
+	Breaker breaker.Settings `yaml:"breaker"`
+}
+
+// The ways of authenticating the calls to the Deployment Manager
+const (
+	// AuthKeycloak gets the tokens from Keycloak with the client credentials grant
+	AuthKeycloak = "keycloak"
+	// AuthOAuth2 gets the tokens from any OAuth2 token endpoint with the client credentials grant
+	AuthOAuth2 = "oauth2"
+	// AuthStatic sends a fixed bearer token
+	AuthStatic = "static"
+	// AuthFile sends the token read from a file on every call, e.g. a projected
+	// Kubernetes service account token
+	AuthFile = "file"
+	// AuthNone sends no token, e.g. to a stubbed Deployment Manager
+	AuthNone = "none"
+)
+
+// Auth selects how the calls to the Deployment Manager are authenticated
+type Auth struct {
+	Mode string `yaml:"mode"`
+	// Token is the bearer token of the static mode
+	Token string `yaml:"token"`
+	// TokenFile is the token file of the file mode
+	TokenFile string `yaml:"tokenFile"`
+	OAuth2    OAuth2 `yaml:"oauth2"`
+}
+
+// OAuth2 configures the client credentials of the oauth2 mode
+type OAuth2 struct {
+	TokenURL     string   `yaml:"tokenURL"`
+	ClientID     string   `yaml:"clientID"`
+	ClientSecret string   `yaml:"clientSecret"`
+	Scopes       []string `yaml:"scopes"`
+	// ClientIDFile and ClientSecretFile are read instead of ClientID and ClientSecret when set
+	ClientIDFile     string     `yaml:"clientIDFile"`
+	ClientSecretFile string     `yaml:"clientSecretFile"`
+	ClientAuth       ClientAuth `yaml:"clientAuth"`
+}
+
+// The ways for a client to authenticate to the token endpoint
+const (
+	// ClientSecretPost sends the client secret in the request body (RFC 6749 section 2.3.1)
+	ClientSecretPost = "client_secret_post"
+	// PrivateKeyJWT sends a JWT signed with the private key of the client (RFC 7523)
+	PrivateKeyJWT = "private_key_jwt"
+	// TLSClientAuth presents the certificate of the client during the TLS handshake (RFC 8705)
+	TLSClientAuth = "tls_client_auth"
+)
+
+// ClientAuth configures how the client authenticates to the token endpoint
+type ClientAuth struct {
+	Method string `yaml:"method"`
+	// PrivateKeyFile is the PEM private key signing the client assertions of
+	// private_key_jwt, and KeyID the kid header of the assertions, if any
+	PrivateKeyFile string `yaml:"privateKeyFile"`
+	KeyID          string `yaml:"keyID"`
+	// CertFile and KeyFile are the PEM client certificate and key of tls_client_auth,
+	// CAFile the PEM certificates trusted for the token endpoint, the system ones by default
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+	CAFile   string `yaml:"caFile"`
+}
+
+// Files returns the files used by the method, so that the configuration is reloaded
+// when they are rotated
+func (a ClientAuth) Files() []string {
+	var files []string
+	for _, file := range []string{a.PrivateKeyFile, a.CertFile, a.KeyFile, a.CAFile} {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// validate checks the settings of the method, prefix being the one of the variables
+func (a ClientAuth) validate(section, prefix string) error {
+	switch a.Method {
+	case ClientSecretPost:
+	case PrivateKeyJWT:
+		return required(section+".clientAuth.privateKeyFile ("+prefix+"CLIENT_ASSERTION_KEY_FILE)", a.PrivateKeyFile)
+	case TLSClientAuth:
+		return errors.Join(
+			required(section+".clientAuth.certFile ("+prefix+"TLS_CERT_FILE)", a.CertFile),
+			required(section+".clientAuth.keyFile ("+prefix+"TLS_KEY_FILE)", a.KeyFile))
+	default:
+		return fmt.Errorf("%s.clientAuth.method (%sCLIENT_AUTH_METHOD): unknown method %q, expected %s, %s or %s",
+			section, prefix, a.Method, ClientSecretPost, PrivateKeyJWT, TLSClientAuth)
+	}
+	return nil
+}
+
+// Keycloak configures the client credentials used to get the tokens in the keycloak
+// mode. The expiry settings apply to the tokens of the oauth2 mode too.
+type Keycloak struct {
+	BaseURL string `yaml:"baseURL"`
+	Realm   string `yaml:"realm"`
+	// IssuerURL replaces BaseURL and Realm when set, the endpoints are then read from
+	// its discovery document, which is fetched again every DiscoveryRefreshInterval
+	IssuerURL                string        `yaml:"issuerURL"`
+	DiscoveryRefreshInterval time.Duration `yaml:"discoveryRefreshInterval"`
+
+	ClientID     string `yaml:"clientID"`
+	ClientSecret string `yaml:"clientSecret"`
+	// ClientIDFile and ClientSecretFile are read instead of ClientID and ClientSecret when set
+	ClientIDFile     string `yaml:"clientIDFile"`
+	ClientSecretFile string `yaml:"clientSecretFile"`
+	// ExpirySkew is how long before its expiry a token stops being used, so that it
+	// does not expire on its way to the Deployment Manager
+	ExpirySkew time.Duration `yaml:"expirySkew"`
+	// RefreshRatio is the fraction of the lifetime of a token after which it is
+	// renewed in the background, 0 disables it
+	RefreshRatio float64    `yaml:"refreshRatio"`
+	ClientAuth   ClientAuth `yaml:"clientAuth"`
+}
+
+// TokenURL returns the token endpoint of the realm when no issuer is set
+func (k Keycloak) TokenURL() string {
+	return oidc.Keycloak(k.BaseURL, k.Realm).TokenEndpoint
+}
+
+// Server configures the HTTP API and the lifecycle of the scheduler
+type Server struct {
+	Port            int           `yaml:"port"`
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+	// ReadinessMaxMissedIntervals is how many intervals a task may go without a
+	// successful run before the replica is reported as not ready
+	ReadinessMaxMissedIntervals int    `yaml:"readinessMaxMissedIntervals"`
+	HistorySize                 int    `yaml:"historySize"`
+	PauseStateFile              string `yaml:"pauseStateFile"`
+}
+
+// Task configures the schedule of a task, Cron takes precedence over Interval
+type Task struct {
+	Interval          time.Duration `yaml:"interval"`
+	Cron              string        `yaml:"cron"`
+	InitialDelay      time.Duration `yaml:"initialDelay"`
+	RunAtStartup      bool          `yaml:"runAtStartup"`
+	ConcurrencyPolicy string        `yaml:"concurrencyPolicy"`
+}
+
+// Tasks configures the tasks of the sidecar
+type Tasks struct {
+	Execute Task `yaml:"execute"`
+	Sync    Task `yaml:"sync"`
+}
+
+// byName returns the tasks by their name in the environment and the API
+func (t *Tasks) byName() map[string]*Task {
+	return map[string]*Task{"execute": &t.Execute, "sync": &t.Sync}
+}
+
+// Get returns the configuration of the named task
+func (t Tasks) Get(name string) (Task, bool) {
+	task, ok := t.byName()[name]
+	if !ok {
+		return Task{}, false
+	}
+	return *task, true
+}
+
+// LeaderElection configures leader election, Mode is either empty (disabled), lease
+// for a coordination.k8s.io Lease or file for a lock file on a shared volume
+type LeaderElection struct {
+	Mode string `yaml:"mode"`
+	// ID is the identity of the replica, the hostname by default
+	ID        string `yaml:"id"`
+	LeaseName string `yaml:"leaseName"`
+	// Namespace of the Lease, the namespace of the pod by default
+	Namespace      string `yaml:"namespace"`
+	File           string `yaml:"file"`
+	leader.Timings `yaml:",inline"`
+}
+
+// Log configures the logs and the secrets masked in them
+type Log struct {
+	Level      string `yaml:"level"`
+	Format     string `yaml:"format"`
+	HTTPBodies bool   `yaml:"httpBodies"`
+	Redact     Redact `yaml:"redact"`
+}
+
+// Redact lists the names masked in the logs on top of the default ones
+type Redact struct {
+	Headers    []string `yaml:"headers"`
+	FormFields []string `yaml:"formFields"`
+	JSONKeys   []string `yaml:"jsonKeys"`
+}
+
+// Tracing configures the OTLP export of the spans, it is off without Endpoint
+type Tracing struct {
+	Endpoint    string `yaml:"endpoint"`
+	ServiceName string `yaml:"serviceName"`
+}
+
+// Default returns the configuration used for everything that is not set
+func Default() *Config {
+	task := Task{Interval: 15 * time.Second, ConcurrencyPolicy: string(ForbidConcurrent)}
+	return &Config{
+		DeployManager: DeployManager{
+			Retry:   retry.DefaultPolicy(),
+			Breaker: breaker.DefaultSettings(),
+		},
+		Auth: Auth{Mode: AuthKeycloak, OAuth2: OAuth2{ClientAuth: ClientAuth{Method: ClientSecretPost}}},
+		Keycloak: Keycloak{
+			ClientAuth:               ClientAuth{Method: ClientSecretPost},
+			DiscoveryRefreshInterval: time.Hour,
+			ExpirySkew:               30 * time.Second,
+			RefreshRatio:             0.8,
+		},
+		Server: Server{
+			Port:                        8083,
+			ShutdownTimeout:             10 * time.Second,
+			ReadinessMaxMissedIntervals: 3,
+			HistorySize:                 500,
+		},
+		Tasks: Tasks{Execute: task, Sync: task},
+		LeaderElection: LeaderElection{
+			LeaseName: "ocm-descriptor-sidecar",
+			Timings:   leader.DefaultTimings(),
+		},
+		Log:           Log{Level: "info", Format: logs.FormatText},
+		WatchInterval: 10 * time.Second,
+	}
+}
+
+// Validate checks the whole configuration and returns every problem found
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	check(checkURL("deployManager.url (DEPLOY_MANAGER_URL)", c.DeployManager.URL, true))
+	if err := c.DeployManager.Retry.Validate(); err != nil {
+		check(fmt.Errorf("deployManager.retry: %w", err))
+	}
+	if err := c.DeployManager.Breaker.Validate(); err != nil {
+		check(fmt.Errorf("deployManager.breaker: %w", err))
+	}
+
+	check(c.validateAuth())
+	if c.Keycloak.ExpirySkew < 0 {
+		check(fmt.Errorf("keycloak.expirySkew (KEYCLOAK_TOKEN_EXPIRY_SKEW): must not be negative, got %s", c.Keycloak.ExpirySkew))
+	}
+	if c.Keycloak.RefreshRatio < 0 || c.Keycloak.RefreshRatio >= 1 {
+		check(fmt.Errorf("keycloak.refreshRatio (KEYCLOAK_TOKEN_REFRESH_RATIO): must be at least 0 and below 1, got %g", c.Keycloak.RefreshRatio))
+	}
+
+	check(checkURL("lighthouseURL (LIGHTHOUSE_BASE_URL)", c.LighthouseURL, false))
+	check(checkURL("matchmakingURL (MATCHMAKING_URL)", c.MatchmakingURL, false))
+
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		check(fmt.Errorf("server.port (HTTP_PORT): %d is not a valid port", c.Server.Port))
+	}
+	check(positive("server.shutdownTimeout (SHUTDOWN_TIMEOUT)", c.Server.ShutdownTimeout))
+	if c.Server.ReadinessMaxMissedIntervals < 1 {
+		check(fmt.Errorf("server.readinessMaxMissedIntervals (READINESS_MAX_MISSED_INTERVALS): must be a positive integer, got %d", c.Server.ReadinessMaxMissedIntervals))
+	}
+	if c.Server.HistorySize < 1 {
+		check(fmt.Errorf("server.historySize (HISTORY_SIZE): must be a positive integer, got %d", c.Server.HistorySize))
+	}
+
+	for name, task := range c.Tasks.byName() {
+		check(task.validate(name))
+	}
+
+	check(c.LeaderElection.validate())
+
+	if _, err := logs.ParseLevel(c.Log.Level); err != nil {
+		check(fmt.Errorf("log.level (LOG_LEVEL): %w", err))
+	}
+	if _, err := logs.ParseFormat(c.Log.Format); err != nil {
+		check(fmt.Errorf("log.format (LOG_FORMAT): %w", err))
+	}
+
+	check(checkURL("tracing.endpoint (OTEL_EXPORTER_OTLP_ENDPOINT)", c.Tracing.Endpoint, false))
+	if c.WatchInterval < 0 {
+		check(fmt.Errorf("watchInterval (CONFIG_WATCH_INTERVAL): must not be negative, got %s", c.WatchInterval))
+	}
+	return errors.Join(errs...)
+}
+
+func (t *Task) validate(name string) error {
+	if err := t.Validate(); err != nil {
+		return fmt.Errorf("tasks.%s (TASK_%s_*): %w", name, strings.ToUpper(name), err)
+	}
+	return nil
+}
+
+// Validate checks the schedule and the concurrency policy of the task
+func (t Task) Validate() error {
+	if t.InitialDelay < 0 {
+		return fmt.Errorf("negative initial delay %s", t.InitialDelay)
+	}
+	if _, err := t.Schedule(); err != nil {
+		return fmt.Errorf("invalid cron expression %q: %w", t.Cron, err)
+	}
+	if t.Cron == "" && t.Interval <= 0 {
+		return fmt.Errorf("interval must be positive, got %s", t.Interval)
+	}
+	_, err := ParseConcurrencyPolicy(t.ConcurrencyPolicy)
+	return err
+}
+
+// Schedule returns the schedule of the cron expression of the task, nil when the task
+// runs every Interval
+func (t Task) Schedule() (cron.Schedule, error) {
+	if t.Cron == "" {
+		return nil, nil
+	}
+	return cron.ParseStandard(t.Cron)
+}
+
+// ConcurrencyPolicy tells what to do when a task is due while its previous run
+// is still in progress, with the same semantics as a Kubernetes CronJob
+type ConcurrencyPolicy string
+
+const (
+	// ForbidConcurrent skips the new run
+	ForbidConcurrent ConcurrencyPolicy = "Forbid"
+	// AllowConcurrent starts the new run next to the previous one
+	AllowConcurrent ConcurrencyPolicy = "Allow"
+	// ReplaceConcurrent cancels the previous run and starts the new one
+	ReplaceConcurrent ConcurrencyPolicy = "Replace"
+)
+
+// ParseConcurrencyPolicy reads a policy name, ignoring case
+func ParseConcurrencyPolicy(v string) (ConcurrencyPolicy, error) {
+	for _, policy := range []ConcurrencyPolicy{ForbidConcurrent, AllowConcurrent, ReplaceConcurrent} {
+		if strings.EqualFold(v, string(policy)) {
+			return policy, nil
+		}
+	}
+	return "", fmt.Errorf("unknown concurrency policy %q, expected %s, %s or %s", v, ForbidConcurrent, AllowConcurrent, ReplaceConcurrent)
+}
+
+// validateAuth checks the settings of the selected authentication mode
+func (c *Config) validateAuth() error {
+	switch strings.ToLower(c.Auth.Mode) {
+	case AuthKeycloak:
+		var errs []error
+		if c.Keycloak.IssuerURL != "" {
+			errs = append(errs,
+				checkURL("keycloak.issuerURL (KEYCLOAK_ISSUER_URL)", c.Keycloak.IssuerURL, true),
+				positive("keycloak.discoveryRefreshInterval (KEYCLOAK_DISCOVERY_REFRESH_INTERVAL)", c.Keycloak.DiscoveryRefreshInterval))
+		} else {
+			errs = append(errs,
+				checkURL("keycloak.baseURL (KEYCLOAK_BASE_URL)", c.Keycloak.BaseURL, true),
+				required("keycloak.realm (KEYCLOAK_REALM)", c.Keycloak.Realm))
+		}
+		errs = append(errs,
+			required("keycloak.clientID (KEYCLOAK_CLIENT_ID or KEYCLOAK_CLIENT_ID_FILE)", c.Keycloak.ClientID),
+			c.Keycloak.ClientAuth.validate("keycloak", "KEYCLOAK_"))
+		// the secret is only needed by the method sending it
+		if c.Keycloak.ClientAuth.Method == ClientSecretPost {
+			errs = append(errs, required("keycloak.clientSecret (KEYCLOAK_CLIENT_SECRET or KEYCLOAK_CLIENT_SECRET_FILE)", c.Keycloak.ClientSecret))
+		}
+		return errors.Join(errs...)
+	case AuthOAuth2:
+		errs := []error{
+			checkURL("auth.oauth2.tokenURL (OAUTH2_TOKEN_URL)", c.Auth.OAuth2.TokenURL, true),
+			required("auth.oauth2.clientID (OAUTH2_CLIENT_ID or OAUTH2_CLIENT_ID_FILE)", c.Auth.OAuth2.ClientID),
+			c.Auth.OAuth2.ClientAuth.validate("auth.oauth2", "OAUTH2_"),
+		}
+		if c.Auth.OAuth2.ClientAuth.Method == ClientSecretPost {
+			errs = append(errs, required("auth.oauth2.clientSecret (OAUTH2_CLIENT_SECRET or OAUTH2_CLIENT_SECRET_FILE)", c.Auth.OAuth2.ClientSecret))
+		}
+		return errors.Join(errs...)
+	case AuthStatic:
+		if c.Auth.Token == "" {
+			return errors.New("auth.token (AUTH_TOKEN) is required when auth.mode is static")
+		}
+	case AuthFile:
+		if c.Auth.TokenFile == "" {
+			return errors.New("auth.tokenFile (AUTH_TOKEN_FILE) is required when auth.mode is file")
+		}
+	case AuthNone:
+	default:
+		return fmt.Errorf("auth.mode (AUTH_MODE): unknown mode %q, expected keycloak, oauth2, static, file or none", c.Auth.Mode)
+	}
+	return nil
+}
+
+func (l *LeaderElection) validate() error {
+	switch strings.ToLower(l.Mode) {
+	case "":
+		return nil
+	case "lease":
+		if l.LeaseName == "" {
+			return errors.New("leaderElection.leaseName (LEADER_ELECTION_LEASE_NAME) is required when leader election uses a lease")
+		}
+	case "file":
+		if l.File == "" {
+			return errors.New("leaderElection.file (LEADER_ELECTION_FILE) is required when leader election uses a file")
+		}
+	default:
+		return fmt.Errorf("leaderElection.mode (LEADER_ELECTION): unknown mode %q, expected lease or file", l.Mode)
+	}
+	return errors.Join(
+		positive("leaderElection.leaseDuration (LEADER_ELECTION_LEASE_DURATION)", l.LeaseDuration),
+		positive("leaderElection.renewDeadline (LEADER_ELECTION_RENEW_DEADLINE)", l.RenewDeadline),
+		positive("leaderElection.retryPeriod (LEADER_ELECTION_RETRY_PERIOD)", l.RetryPeriod),
+	)
+}
+
+func required(name, v string) error {
+	if v == "" {
+		return fmt.Errorf("%s is required", name)
+	}
+	return nil
+}
+
+func positive(name string, d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("%s: must be positive, got %s", name, d)
+	}
+	return nil
+}
+
+// checkURL checks that v is an absolute http or https URL
+func checkURL(name, v string, isRequired bool) error {
+	if v == "" {
+		if isRequired {
+			return required(name, v)
+		}
+		return nil
+	}
+	u, err := url.Parse(v)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s: %q is not an absolute http or https URL", name, v)
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// env returns a getenv function reading the given variables on top of the required ones
+func env(vars map[string]string) func(string) string {
+	all := map[string]string{
+		"DEPLOY_MANAGER_URL":     "http://deploy-manager:8080",
+		"KEYCLOAK_BASE_URL":      "https://keycloak.example.com",
+		"KEYCLOAK_REALM":         "icos-dev",
+		"KEYCLOAK_CLIENT_ID":     "sidecar",
+		"KEYCLOAK_CLIENT_SECRET": "secret",
+	}
+	for k, v := range vars {
+		all[k] = v
+	}
+	return func(name string) string { return all[name] }
+}
+
+func writeFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("should use the defaults", func(t *testing.T) {
+		config, err := Load(nil, env(nil))
+
+		assert.NoError(t, err)
+		assert.Equal(t, 8083, config.Server.Port)
+		assert.Equal(t, 15*time.Second, config.Tasks.Execute.Interval)
+		assert.Equal(t, 3, config.DeployManager.Retry.MaxAttempts)
+		assert.Equal(t, "https://keycloak.example.com/realms/icos-dev/protocol/openid-connect/token", config.Keycloak.TokenURL())
+	})
+
+	t.Run("should apply the file, then the environment, then the flags", func(t *testing.T) {
+		path := writeFile(t, `
+deployManager:
+  url: http://from-file:8080
+  retry:
+    maxAttempts: 5
+server:
+  port: 9000
+  shutdownTimeout: 30s
+tasks:
+  sync:
+    cron: "*/5 * * * *"
+log:
+  level: debug
+`)
+
+		config, err := Load([]string{"-config", path, "-http-port", "9100"}, env(map[string]string{
+			"DEPLOY_MANAGER_URL": "http://from-env:8080",
+			"HTTP_PORT":          "9050",
+		}))
+
+		assert.NoError(t, err)
+		assert.Equal(t, "http://from-env:8080", config.DeployManager.URL)
+		assert.Equal(t, 5, config.DeployManager.Retry.MaxAttempts)
+		assert.Equal(t, 500*time.Millisecond, config.DeployManager.Retry.InitialBackoff)
+		assert.Equal(t, 9100, config.Server.Port)
+		assert.Equal(t, 30*time.Second, config.Server.ShutdownTimeout)
+		assert.Equal(t, "*/5 * * * *", config.Tasks.Sync.Cron)
+		assert.Equal(t, 15*time.Second, config.Tasks.Execute.Interval)
+		assert.Equal(t, "debug", config.Log.Level)
+	})
+
+	t.Run("should have a flag for every setting but the secrets", func(t *testing.T) {
+		config, err := Load([]string{"-task-sync-cron", "*/10 * * * *", "-retry-max-attempts", "5", "-config-watch-interval", "0s"}, env(map[string]string{
+			"TASK_SYNC_CRON": "*/5 * * * *",
+		}))
+
+		assert.NoError(t, err)
+		assert.Equal(t, "*/10 * * * *", config.Tasks.Sync.Cron)
+		assert.Equal(t, 5, config.DeployManager.Retry.MaxAttempts)
+		assert.Equal(t, time.Duration(0), config.WatchInterval)
+
+		_, err = Load([]string{"-keycloak-client-secret", "s3cr3t"}, env(nil))
+		assert.ErrorContains(t, err, "keycloak-client-secret")
+	})
+
+	t.Run("should read the file given by CONFIG_FILE", func(t *testing.T) {
+		path := writeFile(t, "leaderElection:\n  mode: file\n  file: /shared/leader.lock\n")
+
+		config, err := Load(nil, env(map[string]string{"CONFIG_FILE": path}))
+
+		assert.NoError(t, err)
+		assert.Equal(t, "/shared/leader.lock", config.LeaderElection.File)
+		assert.Equal(t, 15*time.Second, config.LeaderElection.LeaseDuration)
+	})
+
+	t.Run("should reject unknown keys in the file", func(t *testing.T) {
+		path := writeFile(t, "deployManager:\n  uri: http://typo:8080\n")
+
+		_, err := Load([]string{"-config", path}, env(nil))
+
+		assert.ErrorContains(t, err, "uri")
+	})
+
+	t.Run("should report every invalid value", func(t *testing.T) {
+		_, err := Load(nil, env(map[string]string{
+			"DEPLOY_MANAGER_URL":           "",
+			"KEYCLOAK_BASE_URL":            "keycloak:8080",
+			"SHUTDOWN_TIMEOUT":             "-1s",
+			"TASK_SYNC_CRON":               "every minute",
+			"LOG_LEVEL":                    "verbose",
+			"KEYCLOAK_TOKEN_REFRESH_RATIO": "1.5",
+		}))
+
+		assert.ErrorContains(t, err, "DEPLOY_MANAGER_URL")
+		assert.ErrorContains(t, err, "KEYCLOAK_BASE_URL")
+		assert.ErrorContains(t, err, "SHUTDOWN_TIMEOUT")
+		assert.ErrorContains(t, err, "TASK_SYNC_*")
+		assert.ErrorContains(t, err, "LOG_LEVEL")
+		assert.ErrorContains(t, err, "KEYCLOAK_TOKEN_REFRESH_RATIO")
+	})
+
+	t.Run("should report values that cannot be parsed", func(t *testing.T) {
+		_, err := Load(nil, env(map[string]string{"RETRY_MAX_ATTEMPTS": "three", "TASK_EXECUTE_INTERVAL": "often"}))
+
+		assert.ErrorContains(t, err, "RETRY_MAX_ATTEMPTS")
+		assert.ErrorContains(t, err, "TASK_EXECUTE_INTERVAL")
+	})
+
+	t.Run("should read the credentials from files", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "client-secret")
+		assert.NoError(t, os.WriteFile(path, []byte("s3cr3t\n"), 0o600))
+
+		config, err := Load(nil, env(map[string]string{"KEYCLOAK_CLIENT_SECRET": "", "KEYCLOAK_CLIENT_SECRET_FILE": path}))
+
+		assert.NoError(t, err)
+		assert.Equal(t, "s3cr3t", config.Keycloak.ClientSecret)
+		assert.Contains(t, config.WatchedFiles(), path)
+	})
+
+	t.Run("should reject a credential set both as a value and as a file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "client-secret")
+		assert.NoError(t, os.WriteFile(path, []byte("s3cr3t"), 0o600))
+
+		_, err := Load(nil, env(map[string]string{"KEYCLOAK_CLIENT_SECRET_FILE": path}))
+
+		assert.ErrorContains(t, err, "KEYCLOAK_CLIENT_SECRET")
+	})
+
+	t.Run("should report a credential file that cannot be read", func(t *testing.T) {
+		_, err := Load(nil, env(map[string]string{"KEYCLOAK_CLIENT_ID": "", "KEYCLOAK_CLIENT_ID_FILE": "/nonexistent/client-id"}))
+
+		assert.ErrorContains(t, err, "KEYCLOAK_CLIENT_ID")
+	})
+	t.Run("should not require the base URL and realm with an issuer", func(t *testing.T) {
+		config, err := Load(nil, env(map[string]string{
+			"KEYCLOAK_BASE_URL":   "",
+			"KEYCLOAK_REALM":      "",
+			"KEYCLOAK_ISSUER_URL": "https://sso.example.com/realms/icos-dev",
+		}))
+
+		assert.NoError(t, err)
+		assert.Equal(t, time.Hour, config.Keycloak.DiscoveryRefreshInterval)
+	})
+	t.Run("should only require the settings of the authentication mode", func(t *testing.T) {
+		_, err := Load(nil, func(name string) string {
+			return map[string]string{"DEPLOY_MANAGER_URL": "http://deploy-manager:8080", "AUTH_MODE": "none"}[name]
+		})
+		assert.NoError(t, err)
+
+		_, err = Load([]string{"-auth-mode", "static"}, env(nil))
+		assert.ErrorContains(t, err, "AUTH_TOKEN")
+
+		_, err = Load(nil, env(map[string]string{"AUTH_MODE": "oauth2", "OAUTH2_TOKEN_URL": "https://sso.example.com/token"}))
+		assert.ErrorContains(t, err, "OAUTH2_CLIENT_ID")
+
+		_, err = Load(nil, env(map[string]string{"AUTH_MODE": "kerberos"}))
+		assert.ErrorContains(t, err, "AUTH_MODE")
+	})
+	t.Run("should require the files of the client authentication method", func(t *testing.T) {
+		config, err := Load(nil, env(map[string]string{
+			"KEYCLOAK_CLIENT_SECRET":             "",
+			"KEYCLOAK_CLIENT_AUTH_METHOD":        "private_key_jwt",
+			"KEYCLOAK_CLIENT_ASSERTION_KEY_FILE": "/etc/sidecar/client.key",
+		}))
+		assert.NoError(t, err)
+		assert.Contains(t, config.WatchedFiles(), "/etc/sidecar/client.key")
+
+		_, err = Load(nil, env(map[string]string{"KEYCLOAK_CLIENT_AUTH_METHOD": "tls_client_auth", "KEYCLOAK_TLS_CERT_FILE": "/etc/sidecar/client.crt"}))
+		assert.ErrorContains(t, err, "KEYCLOAK_TLS_KEY_FILE")
+
+		_, err = Load(nil, env(map[string]string{"AUTH_MODE": "oauth2", "OAUTH2_TOKEN_URL": "https://sso.example.com/token", "OAUTH2_CLIENT_ID": "deployer", "OAUTH2_CLIENT_AUTH_METHOD": "client_secret_basic"}))
+		assert.ErrorContains(t, err, "OAUTH2_CLIENT_AUTH_METHOD")
+	})
+}
+
+func TestParseConcurrencyPolicy(t *testing.T) {
+	policy, err := ParseConcurrencyPolicy("replace")
+	assert.NoError(t, err)
+	assert.Equal(t, ReplaceConcurrent, policy)
+
+	_, err = ParseConcurrencyPolicy("Queue")
+	assert.Error(t, err)
+}
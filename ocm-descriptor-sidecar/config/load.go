@@ -0,0 +1,308 @@
+/*
+  OCM-DESCRIPTOR-SIDECAR
+  Copyright © 2022-2024 EVIDEN
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+  This work has received funding from the European Union's HORIZON research
+  and innovation programme under grant agreement No. 101070177.
+*/
+
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// setting is a value that can be set from the environment and, unless flag is noFlag,
+// from the command line. An empty flag is derived from env, e.g. -task-sync-cron for
+// TASK_SYNC_CRON.
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	set   func(c *Config, v string) error
+}
+
+// noFlag is the flag of the settings that are only read from the environment
+const noFlag = "-"
+
+func settings() []setting {
+	s := []setting{
+		{"DEPLOY_MANAGER_URL", "deploy-manager-url", "Deployment Manager base URL", stringVar(func(c *Config) *string { return &c.DeployManager.URL })},
+		{"RETRY_MAX_ATTEMPTS", "", "", intVar(func(c *Config) *int { return &c.DeployManager.Retry.MaxAttempts })},
+		{"RETRY_INITIAL_BACKOFF", "", "", durationVar(func(c *Config) *time.Duration { return &c.DeployManager.Retry.InitialBackoff })},
+		{"RETRY_MAX_BACKOFF", "", "", durationVar(func(c *Config) *time.Duration { return &c.DeployManager.Retry.MaxBackoff })},
+		{"RETRY_MULTIPLIER", "", "", floatVar(func(c *Config) *float64 { return &c.DeployManager.Retry.Multiplier })},
+		{"RETRY_JITTER", "", "", floatVar(func(c *Config) *float64 { return &c.DeployManager.Retry.Jitter })},
+		{"BREAKER_FAILURE_THRESHOLD", "", "", intVar(func(c *Config) *int { return &c.DeployManager.Breaker.FailureThreshold })},
+		{"BREAKER_OPEN_TIMEOUT", "", "", durationVar(func(c *Config) *time.Duration { return &c.DeployManager.Breaker.OpenTimeout })},
+		{"BREAKER_HALF_OPEN_MAX_CALLS", "", "", intVar(func(c *Config) *int { return &c.DeployManager.Breaker.HalfOpenMaxCalls })},
+
+		{"AUTH_MODE", "auth-mode", "keycloak, oauth2, static, file or none", stringVar(func(c *Config) *string { return &c.Auth.Mode })},
+		// the token has no flag, it would show up in the process list
+		{"AUTH_TOKEN", noFlag, "", stringVar(func(c *Config) *string { return &c.Auth.Token })},
+		{"AUTH_TOKEN_FILE", "", "", stringVar(func(c *Config) *string { return &c.Auth.TokenFile })},
+		{"OAUTH2_TOKEN_URL", "", "", stringVar(func(c *Config) *string { return &c.Auth.OAuth2.TokenURL })},
+		{"OAUTH2_CLIENT_ID", "", "", stringVar(func(c *Config) *string { return &c.Auth.OAuth2.ClientID })},
+		{"OAUTH2_CLIENT_SECRET", noFlag, "", stringVar(func(c *Config) *string { return &c.Auth.OAuth2.ClientSecret })},
+		{"OAUTH2_CLIENT_ID_FILE", "", "", stringVar(func(c *Config) *string { return &c.Auth.OAuth2.ClientIDFile })},
+		{"OAUTH2_CLIENT_SECRET_FILE", "", "", stringVar(func(c *Config) *string { return &c.Auth.OAuth2.ClientSecretFile })},
+		{"OAUTH2_SCOPES", "", "", listVar(func(c *Config) *[]string { return &c.Auth.OAuth2.Scopes })},
+
+		{"KEYCLOAK_BASE_URL", "keycloak-base-url", "Keycloak base URL", stringVar(func(c *Config) *string { return &c.Keycloak.BaseURL })},
+		{"KEYCLOAK_REALM", "keycloak-realm", "Keycloak realm", stringVar(func(c *Config) *string { return &c.Keycloak.Realm })},
+		{"KEYCLOAK_ISSUER_URL", "keycloak-issuer-url", "OIDC issuer URL, replaces the Keycloak base URL and realm", stringVar(func(c *Config) *string { return &c.Keycloak.IssuerURL })},
+		{"KEYCLOAK_DISCOVERY_REFRESH_INTERVAL", "", "", durationVar(func(c *Config) *time.Duration { return &c.Keycloak.DiscoveryRefreshInterval })},
+		{"KEYCLOAK_CLIENT_ID", "keycloak-client-id", "Keycloak client ID", stringVar(func(c *Config) *string { return &c.Keycloak.ClientID })},
+		// the secret has no flag, it would show up in the process list
+		{"KEYCLOAK_CLIENT_SECRET", noFlag, "", stringVar(func(c *Config) *string { return &c.Keycloak.ClientSecret })},
+		{"KEYCLOAK_CLIENT_ID_FILE", "", "", stringVar(func(c *Config) *string { return &c.Keycloak.ClientIDFile })},
+		{"KEYCLOAK_CLIENT_SECRET_FILE", "", "", stringVar(func(c *Config) *string { return &c.Keycloak.ClientSecretFile })},
+		{"KEYCLOAK_TOKEN_EXPIRY_SKEW", "", "", durationVar(func(c *Config) *time.Duration { return &c.Keycloak.ExpirySkew })},
+		{"KEYCLOAK_TOKEN_REFRESH_RATIO", "", "", floatVar(func(c *Config) *float64 { return &c.Keycloak.RefreshRatio })},
+
+		{"LIGHTHOUSE_BASE_URL", "", "", stringVar(func(c *Config) *string { return &c.LighthouseURL })},
+		{"MATCHMAKING_URL", "", "", stringVar(func(c *Config) *string { return &c.MatchmakingURL })},
+
+		{"HTTP_PORT", "http-port", "port of the HTTP API", intVar(func(c *Config) *int { return &c.Server.Port })},
+		{"SHUTDOWN_TIMEOUT", "shutdown-timeout", "how long in-flight runs may take to complete on shutdown", durationVar(func(c *Config) *time.Duration { return &c.Server.ShutdownTimeout })},
+		{"READINESS_MAX_MISSED_INTERVALS", "", "", intVar(func(c *Config) *int { return &c.Server.ReadinessMaxMissedIntervals })},
+		{"HISTORY_SIZE", "", "", intVar(func(c *Config) *int { return &c.Server.HistorySize })},
+		{"PAUSE_STATE_FILE", "pause-state-file", "file keeping the paused tasks across restarts", stringVar(func(c *Config) *string { return &c.Server.PauseStateFile })},
+
+		{"LEADER_ELECTION", "leader-election", "leader election mode, lease or file", stringVar(func(c *Config) *string { return &c.LeaderElection.Mode })},
+		{"LEADER_ELECTION_ID", "", "", stringVar(func(c *Config) *string { return &c.LeaderElection.ID })},
+		{"LEADER_ELECTION_LEASE_NAME", "", "", stringVar(func(c *Config) *string { return &c.LeaderElection.LeaseName })},
+		{"LEADER_ELECTION_NAMESPACE", "", "", stringVar(func(c *Config) *string { return &c.LeaderElection.Namespace })},
+		{"LEADER_ELECTION_FILE", "", "", stringVar(func(c *Config) *string { return &c.LeaderElection.File })},
+		{"LEADER_ELECTION_LEASE_DURATION", "", "", durationVar(func(c *Config) *time.Duration { return &c.LeaderElection.LeaseDuration })},
+		{"LEADER_ELECTION_RENEW_DEADLINE", "", "", durationVar(func(c *Config) *time.Duration { return &c.LeaderElection.RenewDeadline })},
+		{"LEADER_ELECTION_RETRY_PERIOD", "", "", durationVar(func(c *Config) *time.Duration { return &c.LeaderElection.RetryPeriod })},
+
+		{"LOG_LEVEL", "log-level", "debug, info, warn or error", stringVar(func(c *Config) *string { return &c.Log.Level })},
+		{"LOG_FORMAT", "log-format", "text or json", stringVar(func(c *Config) *string { return &c.Log.Format })},
+		{"LOG_HTTP_BODIES", "", "", boolVar(func(c *Config) *bool { return &c.Log.HTTPBodies })},
+		{"REDACT_HEADERS", "", "", listVar(func(c *Config) *[]string { return &c.Log.Redact.Headers })},
+		{"REDACT_FORM_FIELDS", "", "", listVar(func(c *Config) *[]string { return &c.Log.Redact.FormFields })},
+		{"REDACT_JSON_KEYS", "", "", listVar(func(c *Config) *[]string { return &c.Log.Redact.JSONKeys })},
+
+		{"OTEL_EXPORTER_OTLP_ENDPOINT", "", "", stringVar(func(c *Config) *string { return &c.Tracing.Endpoint })},
+		{"OTEL_SERVICE_NAME", "", "", stringVar(func(c *Config) *string { return &c.Tracing.ServiceName })},
+
+		{"CONFIG_WATCH_INTERVAL", "", "", durationVar(func(c *Config) *time.Duration { return &c.WatchInterval })},
+	}
+	for _, section := range []struct {
+		prefix     string
+		clientAuth func(*Config) *ClientAuth
+	}{
+		{"KEYCLOAK_", func(c *Config) *ClientAuth { return &c.Keycloak.ClientAuth }},
+		{"OAUTH2_", func(c *Config) *ClientAuth { return &c.Auth.OAuth2.ClientAuth }},
+	} {
+		prefix, clientAuth := section.prefix, section.clientAuth
+		s = append(s,
+			setting{prefix + "CLIENT_AUTH_METHOD", "", "", stringVar(func(c *Config) *string { return &clientAuth(c).Method })},
+			setting{prefix + "CLIENT_ASSERTION_KEY_FILE", "", "", stringVar(func(c *Config) *string { return &clientAuth(c).PrivateKeyFile })},
+			setting{prefix + "CLIENT_ASSERTION_KEY_ID", "", "", stringVar(func(c *Config) *string { return &clientAuth(c).KeyID })},
+			setting{prefix + "TLS_CERT_FILE", "", "", stringVar(func(c *Config) *string { return &clientAuth(c).CertFile })},
+			setting{prefix + "TLS_KEY_FILE", "", "", stringVar(func(c *Config) *string { return &clientAuth(c).KeyFile })},
+			setting{prefix + "TLS_CA_FILE", "", "", stringVar(func(c *Config) *string { return &clientAuth(c).CAFile })},
+		)
+	}
+	for _, name := range []string{"execute", "sync"} {
+		task := func(c *Config) *Task { return c.Tasks.byName()[name] }
+		prefix := "TASK_" + strings.ToUpper(name) + "_"
+		s = append(s,
+			setting{prefix + "INTERVAL", "", "", durationVar(func(c *Config) *time.Duration { return &task(c).Interval })},
+			setting{prefix + "CRON", "", "", stringVar(func(c *Config) *string { return &task(c).Cron })},
+			setting{prefix + "INITIAL_DELAY", "", "", durationVar(func(c *Config) *time.Duration { return &task(c).InitialDelay })},
+			setting{prefix + "RUN_AT_STARTUP", "", "", boolVar(func(c *Config) *bool { return &task(c).RunAtStartup })},
+			setting{prefix + "CONCURRENCY_POLICY", "", "", stringVar(func(c *Config) *string { return &task(c).ConcurrencyPolicy })},
+		)
+	}
+	for i := range s {
+		if s[i].flag == "" {
+			s[i].flag = strings.ToLower(strings.ReplaceAll(s[i].env, "_", "-"))
+		}
+	}
+	return s
+}
+
+// Load builds the configuration from the defaults, then the YAML file given by the
+// -config flag or CONFIG_FILE, then the environment read with getenv, then the other
+// flags in args, and validates it. The error lists every problem found.
+func Load(args []string, getenv func(string) string) (*Config, error) {
+	all := settings()
+	flags := flag.NewFlagSet("ocm-descriptor-sidecar", flag.ContinueOnError)
+	file := flags.String("config", getenv("CONFIG_FILE"), "YAML configuration file")
+	for _, s := range all {
+		switch {
+		case s.flag == noFlag:
+		case s.usage == "":
+			flags.String(s.flag, "", "overrides "+s.env)
+		default:
+			flags.String(s.flag, "", s.usage+" (overrides "+s.env+")")
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	config := Default()
+	if *file != "" {
+		if err := config.readFile(*file); err != nil {
+			return nil, err
+		}
+		config.File = *file
+	}
+
+	var errs []error
+	for _, s := range all {
+		if v := getenv(s.env); v != "" {
+			if err := s.set(config, v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.env, err))
+			}
+		}
+	}
+	flags.Visit(func(f *flag.Flag) {
+		for _, s := range all {
+			if s.flag == f.Name {
+				if err := s.set(config, f.Value.String()); err != nil {
+					errs = append(errs, fmt.Errorf("-%s: %w", f.Name, err))
+				}
+			}
+		}
+	})
+	if err := config.readCredentials(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// readFile decodes the YAML file over the configuration, rejecting unknown keys
+func (c *Config) readFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config file: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(b))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// readCredentials reads the credentials whose file is set, ignoring the surrounding
+// whitespace such as the trailing newline of a file edited by hand
+func (c *Config) readCredentials() error {
+	var errs []error
+	for _, credential := range c.credentials() {
+		if *credential.file == "" {
+			continue
+		}
+		if *credential.value != "" {
+			errs = append(errs, fmt.Errorf("%s: set either the value or the file, not both", credential.name))
+			continue
+		}
+		b, err := os.ReadFile(*credential.file)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", credential.name, err))
+			continue
+		}
+		*credential.value = strings.TrimSpace(string(b))
+	}
+	return errors.Join(errs...)
+}
+
+func stringVar(field func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		*field(c) = v
+		return nil
+	}
+}
+
+func intVar(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", v)
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func floatVar(field func(*Config) *float64) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", v)
+		}
+		*field(c) = f
+		return nil
+	}
+}
+
+func boolVar(field func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", v)
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func durationVar(field func(*Config) *time.Duration) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration", v)
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+// listVar reads a comma-separated list
+func listVar(field func(*Config) *[]string) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		var list []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*field(c) = list
+		return nil
+	}
+}
@@ -0,0 +1,101 @@
+/*
+  OCM-DESCRIPTOR-SIDECAR
+  Copyright © 2022-2024 EVIDEN
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+  This work has received funding from the European Union's HORIZON research
+  and innovation programme under grant agreement No. 101070177.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"icos/server/ocm-descriptor-sidecar/responses"
+	"icos/server/ocm-descriptor-sidecar/utils/logs"
+	"net/http"
+	"time"
+)
+
+// defaultDrainTimeout bounds how long a drain request waits for the in-flight runs
+const defaultDrainTimeout = 5 * time.Minute
+
+type PauseResponse struct {
+	Paused []string `json:"paused"`
+}
+
+type LogLevelResponse struct {
+	Level string `json:"level"`
+}
+
+// AdminPause pauses the task given by the task query parameter, or every task without it
+func (server *Server) AdminPause(w http.ResponseWriter, r *http.Request) {
+	server.adminControl(w, r, func(ctx context.Context, name string) error {
+		return server.Pause(name)
+	})
+}
+
+// AdminResume resumes the task given by the task query parameter, or every task without it
+func (server *Server) AdminResume(w http.ResponseWriter, r *http.Request) {
+	server.adminControl(w, r, func(ctx context.Context, name string) error {
+		return server.Resume(name)
+	})
+}
+
+// AdminDrain pauses the task given by the task query parameter, or every task without
+// it, and answers once the in-flight runs are over
+func (server *Server) AdminDrain(w http.ResponseWriter, r *http.Request) {
+	server.adminControl(w, r, func(ctx context.Context, name string) error {
+		ctx, cancel := context.WithTimeout(ctx, defaultDrainTimeout)
+		defer cancel()
+		return server.Drain(ctx, name)
+	})
+}
+
+func (server *Server) adminControl(w http.ResponseWriter, r *http.Request, control func(ctx context.Context, name string) error) {
+	name := r.URL.Query().Get("task")
+	if name != "" && server.runner(name) == nil {
+		responses.ERROR(w, http.StatusNotFound, errors.New("unknown task "+name))
+		return
+	}
+	if err := control(r.Context(), name); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+			responses.ERROR(w, http.StatusGatewayTimeout, err)
+			return
+		}
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+	responses.JSON(w, http.StatusOK, PauseResponse{Paused: server.PausedTasks()})
+}
+
+// AdminLogLevel returns the log level. A POST changes it to the level query parameter
+// first, while a GET carrying one is rejected rather than silently ignored.
+func (server *Server) AdminLogLevel(w http.ResponseWriter, r *http.Request) {
+	level := r.URL.Query().Get("level")
+	if level != "" && r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		responses.ERROR(w, http.StatusMethodNotAllowed, errors.New("the level can only be changed with POST"))
+		return
+	}
+	if r.Method == http.MethodPost {
+		if err := logs.SetLevel(level); err != nil {
+			responses.ERROR(w, http.StatusBadRequest, err)
+			return
+		}
+		logger.Info("log level changed", "level", logs.Level())
+	}
+	responses.JSON(w, http.StatusOK, LogLevelResponse{Level: logs.Level()})
+}
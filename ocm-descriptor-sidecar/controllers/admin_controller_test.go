@@ -0,0 +1,41 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"icos/server/ocm-descriptor-sidecar/utils/logs"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminLogLevel(t *testing.T) {
+	defer logs.SetLevel(logs.Level())
+	server := &Server{}
+
+	t.Run("should change the log level", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		server.AdminLogLevel(w, httptest.NewRequest("POST", "/admin/log-level?level=debug", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"level":"debug"}`, w.Body.String())
+		assert.Equal(t, "debug", logs.Level())
+	})
+
+	t.Run("should reject an unknown level", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		server.AdminLogLevel(w, httptest.NewRequest("POST", "/admin/log-level?level=verbose", nil))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Equal(t, "debug", logs.Level())
+	})
+
+	t.Run("should not change the level on GET", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		server.AdminLogLevel(w, httptest.NewRequest("GET", "/admin/log-level?level=error", nil))
+
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+		assert.Equal(t, "debug", logs.Level())
+	})
+}
@@ -21,46 +21,207 @@
 package controllers
 
 import (
+	"context"
+	"errors"
+	"icos/server/ocm-descriptor-sidecar/config"
+	"icos/server/ocm-descriptor-sidecar/models"
+	"icos/server/ocm-descriptor-sidecar/utils/leader"
 	"icos/server/ocm-descriptor-sidecar/utils/logs"
+	"icos/server/ocm-descriptor-sidecar/utils/metrics"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
 )
 
+// logger is the logger of the scheduler and of the HTTP API
+var logger = logs.Component("scheduler")
+
 type Server struct {
 	Router *mux.Router
+	// Addr is the address the HTTP server listens on
+	Addr string
+	// ShutdownTimeout is how long in-flight runs may take to complete once the
+	// scheduler is stopped before their requests are cancelled
+	ShutdownTimeout time.Duration
+
+	// elector is nil when leader election is disabled
+	elector *leader.Elector
+	history *History
+
+	// startedAt and stopping are used by the readiness probe
+	startedAt time.Time
+	stopping  atomic.Bool
+	// runCtx is the context of the runs, it is set once Run has started
+	runCtx context.Context
+	// pauseStateFile keeps the paused tasks across restarts when set
+	pauseStateFile string
+	// pauseStateMu serializes the writes of the pause state file
+	pauseStateMu sync.Mutex
+
+	mu sync.Mutex
+	// config is the configuration in use, Reload compares the next one with it
+	config  *config.Config
+	tasks   []*Task
+	runners []*taskRunner
+}
+
+// Init prepares the HTTP API and the scheduler from the validated configuration
+func (server *Server) Init(config *config.Config) {
+	server.Router = mux.NewRouter()
+	server.initializeRoutes()
+	server.Addr = ":" + strconv.Itoa(config.Server.Port)
+	server.ShutdownTimeout = config.Server.ShutdownTimeout
+	readinessMaxMissedIntervals = config.Server.ReadinessMaxMissedIntervals
+	server.history = NewHistory(config.Server.HistorySize)
+	server.pauseStateFile = config.Server.PauseStateFile
+
+	lighthouseBaseURL = config.LighthouseURL
+	matchmackerBaseURL = config.MatchmakingURL
+	models.Configure(config.Keycloak)
+	configureDeployManager(config.DeployManager, models.NewTokenRequester(config.Auth))
+
+	tasks, err := NewTasks(config.Tasks)
+	if err != nil {
+		logs.Fatal(logger, "invalid task configuration", "error", err)
+	}
+	server.config = config
+	server.tasks = tasks
+	elector, err := NewLeaderElector(config.LeaderElection)
+	if err != nil {
+		logs.Fatal(logger, "cannot set up leader election", "error", err)
+	}
+	server.elector = elector
+	if elector == nil {
+		metrics.Leader.Set(1)
+	}
 }
 
-func (server *Server) Init() {
-	// server.Router = mux.NewRouter()
-	// server.initializeRoutes()
+// isLeader reports whether this replica may run the tasks
+func (server *Server) isLeader() bool {
+	return server.elector == nil || server.elector.IsLeader()
 }
 
-func (server *Server) Run() {
-	logs.Logger.Println("Starting to Schedule")
-	ticker := time.NewTicker(15 * time.Second) // TODO parametrize
-	quit := make(chan struct{})
-	// go func() {
-	for {
-		select {
-		case <-ticker.C:
-			// do stuff
-			status, err := Schedule()
-			if err != nil {
-				logs.Logger.Println("ERROR " + err.Error())
-			} else {
-				logs.Logger.Println("Status of the execution: " + status)
-			}
-		case <-quit:
-			ticker.Stop()
-			return
+// LeaderStatus returns the leader election state of this replica
+func (server *Server) LeaderStatus() LeaderStatus {
+	if server.elector == nil {
+		return LeaderStatus{Leader: true}
+	}
+	return LeaderStatus{
+		Enabled:  true,
+		Identity: server.elector.Identity(),
+		Leader:   server.elector.IsLeader(),
+	}
+}
+
+// runner returns the runner of the named task, or nil if there is no such task
+func (server *Server) runner(name string) *taskRunner {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	for _, runner := range server.runners {
+		if runner.name() == name {
+			return runner
 		}
 	}
-	// }()
-	// after stopping server
-	// logs.Logger.Println("Closing connections ...")
+	return nil
+}
+
+// TaskStatuses returns a snapshot of every scheduled task
+func (server *Server) TaskStatuses() []TaskStatus {
+	server.mu.Lock()
+	defer server.mu.Unlock()
 
-	// var shutdownTimeout = flag.Duration("shutdown-timeout", 10*time.Second, "shutdown timeout (5s,5m,5h) before connections are cancelled")
-	// _, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
-	// defer cancel()
+	statuses := make([]TaskStatus, 0, len(server.runners))
+	for _, runner := range server.runners {
+		statuses = append(statuses, runner.Status())
+	}
+	return statuses
+}
+
+// Run serves the HTTP API and schedules the tasks until ctx is cancelled, then waits
+// up to ShutdownTimeout for the in-flight runs before cancelling them
+func (server *Server) Run(ctx context.Context) {
+	server.startedAt = time.Now()
+
+	httpServer := &http.Server{Addr: server.Addr, Handler: server.Router}
+	go func() {
+		logger.Info("listening", "addr", server.Addr)
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logs.Fatal(logger, "HTTP server failed", "error", err)
+		}
+	}()
+
+	// runCtx is detached from ctx so that in-flight runs are not interrupted as soon as a signal arrives
+	runCtx, cancelRuns := context.WithCancel(context.Background())
+	defer cancelRuns()
+
+	server.mu.Lock()
+	tasks := server.tasks
+	logger.Info("starting to schedule", "tasks", len(tasks))
+	server.runCtx = runCtx
+	server.runners = server.runners[:0]
+	for _, task := range tasks {
+		runner := newTaskRunner(task)
+		runner.canRun = server.isLeader
+		runner.record = server.history.Add
+		server.runners = append(server.runners, runner)
+		metrics.TaskPaused.WithLabelValues(task.Name).Set(0)
+	}
+	runners := server.runners
+	server.mu.Unlock()
+	if err := server.loadPauseState(); err != nil {
+		logs.Fatal(logger, "cannot load the pause state", "error", err)
+	}
+
+	// the lock is only released once the in-flight runs are over, so that the next
+	// leader cannot start a run next to them
+	electionCtx, stopElection := context.WithCancel(context.Background())
+	electionDone := make(chan struct{})
+	if server.elector != nil {
+		go func() {
+			defer close(electionDone)
+			server.elector.Run(electionCtx)
+		}()
+	} else {
+		close(electionDone)
+	}
+
+	var wg sync.WaitGroup
+	for _, runner := range runners {
+		wg.Add(1)
+		go func(runner *taskRunner) {
+			defer wg.Done()
+			runner.loop(ctx, runCtx)
+		}(runner)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	<-ctx.Done()
+	server.stopping.Store(true)
+	logger.Info("stopping scheduler, waiting for in-flight runs", "timeout", server.ShutdownTimeout)
+	select {
+	case <-done:
+	case <-time.After(server.ShutdownTimeout):
+		logger.Warn("shutdown timeout reached, cancelling in-flight runs")
+		cancelRuns()
+		<-done
+	}
+	stopElection()
+	<-electionDone
+	logger.Info("scheduler stopped")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), server.ShutdownTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		logger.Error("HTTP server shutdown failed", "error", err)
+	}
+	logger.Info("HTTP server stopped")
 }
@@ -0,0 +1,120 @@
+/*
+  OCM-DESCRIPTOR-SIDECAR
+  Copyright © 2022-2024 EVIDEN
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+  This work has received funding from the European Union's HORIZON research
+  and innovation programme under grant agreement No. 101070177.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"icos/server/ocm-descriptor-sidecar/config"
+	"icos/server/ocm-descriptor-sidecar/models"
+	"icos/server/ocm-descriptor-sidecar/utils/breaker"
+	"icos/server/ocm-descriptor-sidecar/utils/metrics"
+	"icos/server/ocm-descriptor-sidecar/utils/retry"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// deployManagerClient is the configuration of the Deployment Manager calls. It is
+// replaced as a whole on reload, and every run keeps the one it started with.
+type deployManagerClient struct {
+	url       string
+	retry     retry.Policy
+	breaker   *breaker.Breaker
+	requester models.TokenRequester
+}
+
+var deployManager atomic.Pointer[deployManagerClient]
+
+func init() {
+	deployManager.Store(&deployManagerClient{
+		retry:     retry.DefaultPolicy(),
+		breaker:   newDeployManagerBreaker(breaker.DefaultSettings()),
+		requester: models.KeycloakTokenRequester{},
+	})
+}
+
+// configureDeployManager applies the configuration to the next runs, which get their
+// tokens from requester. The circuit breaker keeps its state unless its settings changed.
+func configureDeployManager(config config.DeployManager, requester models.TokenRequester) {
+	b := deployManager.Load().breaker
+	if b.Settings() != config.Breaker {
+		b = newDeployManagerBreaker(config.Breaker)
+	}
+	deployManager.Store(&deployManagerClient{url: config.URL, retry: config.Retry, breaker: b, requester: requester})
+}
+
+// newDeployManagerBreaker creates the circuit breaker guarding the Deployment Manager calls
+func newDeployManagerBreaker(settings breaker.Settings) *breaker.Breaker {
+	b := breaker.New("deploy-manager", settings)
+	metrics.CircuitBreakerState.WithLabelValues("deploy-manager").Set(float64(breaker.Closed))
+	b.OnStateChange = func(name string, from, to breaker.State) {
+		metrics.CircuitBreakerState.WithLabelValues(name).Set(float64(to))
+		logger.Warn("circuit breaker state changed", "breaker", name, "from", from, "to", to)
+	}
+	return b
+}
+
+// recordOutcome reports the outcome of a call that reached the Deployment Manager to the
+// circuit breaker. Only failures that are retried count as the upstream being unhealthy.
+func (dm *deployManagerClient) recordOutcome(err error) {
+	switch {
+	case err == nil:
+		dm.breaker.Success()
+	case errors.Is(err, context.Canceled):
+		dm.breaker.Release()
+	case retry.IsRetryable(err):
+		dm.breaker.Failure()
+	default:
+		dm.breaker.Success()
+	}
+}
+
+// StatusError is returned when the Deployment Manager answers with a non 2xx status
+type StatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return "deployment manager returned " + e.Status
+}
+
+// classifyResponse turns the outcome of a Deployment Manager call into an error,
+// marking network errors, 5xx and 429 responses as retryable
+func classifyResponse(resp *http.Response, err error) error {
+	if err != nil {
+		// the run itself was cancelled, there is no point in trying again
+		if errors.Is(err, context.Canceled) {
+			return err
+		}
+		return retry.Retryable(err, 0)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	statusErr := &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		retryAfter, _ := retry.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		return retry.Retryable(statusErr, retryAfter)
+	}
+	return statusErr
+}
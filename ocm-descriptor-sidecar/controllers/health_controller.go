@@ -0,0 +1,125 @@
+/*
+  OCM-DESCRIPTOR-SIDECAR
+  Copyright © 2022-2024 EVIDEN
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+  This work has received funding from the European Union's HORIZON research
+  and innovation programme under grant agreement No. 101070177.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"icos/server/ocm-descriptor-sidecar/models"
+	"icos/server/ocm-descriptor-sidecar/responses"
+	"net/http"
+	"time"
+)
+
+const readinessTokenTimeout = 5 * time.Second
+
+// readinessMaxMissedIntervals is how many intervals a task may go without a successful run
+var readinessMaxMissedIntervals = 3
+
+type HealthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Healthz is the liveness probe, it succeeds as long as the process serves requests
+func (server *Server) Healthz(w http.ResponseWriter, r *http.Request) {
+	responses.JSON(w, http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// Readyz is the readiness probe, it checks that a token can be obtained, from the
+// outcome of the last token request, and that every task had a successful run recently
+func (server *Server) Readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTokenTimeout)
+	defer cancel()
+
+	checks, ready := server.readinessChecks(ctx, time.Now())
+	if !ready {
+		responses.JSON(w, http.StatusServiceUnavailable, HealthResponse{Status: "unavailable", Checks: checks})
+		return
+	}
+	responses.JSON(w, http.StatusOK, HealthResponse{Status: "ok", Checks: checks})
+}
+
+// readinessChecks runs the readiness checks and reports whether all of them passed
+func (server *Server) readinessChecks(ctx context.Context, now time.Time) (map[string]string, bool) {
+	checks := map[string]string{}
+	ready := true
+	fail := func(name, reason string) {
+		checks[name] = reason
+		ready = false
+	}
+
+	if server.stopping.Load() {
+		fail("scheduler", "shutting down")
+	} else {
+		checks["scheduler"] = "ok"
+	}
+
+	if err := models.TokenStatus(ctx, deployManager.Load().requester); err != nil {
+		fail("token", err.Error())
+	} else {
+		checks["token"] = "ok"
+	}
+
+	// followers do not run the tasks, their results say nothing about this replica
+	if !server.isLeader() {
+		return checks, ready
+	}
+	server.mu.Lock()
+	runners := append([]*taskRunner(nil), server.runners...)
+	server.mu.Unlock()
+	for _, runner := range runners {
+		name := "task:" + runner.name()
+		// a paused task is not expected to run, it does not make the replica unready
+		if runner.paused.Load() {
+			checks[name] = "paused"
+			continue
+		}
+		if reason := server.taskReadiness(runner, now); reason != "" {
+			fail(name, reason)
+		} else {
+			checks[name] = "ok"
+		}
+	}
+	return checks, ready
+}
+
+// taskReadiness returns why the task is not ready, or an empty string if it is
+func (server *Server) taskReadiness(runner *taskRunner, now time.Time) string {
+	status := runner.Status()
+	task := runner.currentTask()
+	// before the first success, give the task its initial delay on top of the allowed intervals
+	since := server.startedAt.Add(task.InitialDelay)
+	if status.LastSuccess != nil {
+		since = *status.LastSuccess
+	}
+	// a resumed task gets the same allowance as a task that just started
+	runner.mu.Lock()
+	if runner.resumedAt.After(since) {
+		since = runner.resumedAt
+	}
+	runner.mu.Unlock()
+	allowed := time.Duration(readinessMaxMissedIntervals) * task.period(since)
+	if elapsed := now.Sub(since); elapsed > allowed {
+		return fmt.Sprintf("no successful run for %s, allowed %s", elapsed.Round(time.Second), allowed)
+	}
+	return ""
+}
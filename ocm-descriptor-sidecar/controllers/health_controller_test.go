@@ -0,0 +1,30 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaskReadiness(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	server := &Server{startedAt: start}
+	newRunner := func() *taskRunner {
+		return newTaskRunner(&Task{Name: TaskExecute, Interval: 10 * time.Second})
+	}
+
+	t.Run("should be ready during the first intervals", func(t *testing.T) {
+		runner := newRunner()
+
+		assert.Empty(t, server.taskReadiness(runner, start.Add(25*time.Second)))
+	})
+
+	t.Run("should not be ready without a success for too many intervals", func(t *testing.T) {
+		runner := newRunner()
+		runner.lastSuccess = start.Add(time.Minute)
+
+		assert.Empty(t, server.taskReadiness(runner, start.Add(80*time.Second)))
+		assert.NotEmpty(t, server.taskReadiness(runner, start.Add(95*time.Second)))
+	})
+}
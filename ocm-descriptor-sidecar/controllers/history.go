@@ -0,0 +1,102 @@
+/*
+  OCM-DESCRIPTOR-SIDECAR
+  Copyright © 2022-2024 EVIDEN
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+  This work has received funding from the European Union's HORIZON research
+  and innovation programme under grant agreement No. 101070177.
+*/
+
+package controllers
+
+import (
+	"sync"
+	"time"
+)
+
+// History keeps the results of the last runs in a bounded ring buffer
+type History struct {
+	mu      sync.Mutex
+	results []ScheduleResult
+	next    int
+	full    bool
+}
+
+func NewHistory(size int) *History {
+	return &History{results: make([]ScheduleResult, size)}
+}
+
+// Add records a result, evicting the oldest one when the history is full
+func (h *History) Add(result ScheduleResult) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.results[h.next] = result
+	h.next = (h.next + 1) % len(h.results)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// HistoryFilter selects results, zero fields match everything
+type HistoryFilter struct {
+	Task    string
+	Outcome Outcome
+	Since   time.Time
+	Until   time.Time
+}
+
+func (f HistoryFilter) match(result ScheduleResult) bool {
+	return (f.Task == "" || result.Task == f.Task) &&
+		(f.Outcome == "" || result.Outcome == f.Outcome) &&
+		(f.Since.IsZero() || !result.StartedAt.Before(f.Since)) &&
+		(f.Until.IsZero() || result.StartedAt.Before(f.Until))
+}
+
+// Query returns the page of matching results, newest first, and the total number of matches
+func (h *History) Query(filter HistoryFilter, offset, limit int) ([]ScheduleResult, int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	count := h.next
+	if h.full {
+		count = len(h.results)
+	}
+	page := []ScheduleResult{}
+	total := 0
+	for i := 0; i < count; i++ {
+		result := h.results[(h.next-1-i+len(h.results))%len(h.results)]
+		if !filter.match(result) {
+			continue
+		}
+		if total >= offset && len(page) < limit {
+			page = append(page, result)
+		}
+		total++
+	}
+	return page, total
+}
+
+// Find returns the result of the given run if it is still in the history
+func (h *History) Find(runID string) (ScheduleResult, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, result := range h.results {
+		if result.RunID != "" && result.RunID == runID {
+			return result, true
+		}
+	}
+	return ScheduleResult{}, false
+}
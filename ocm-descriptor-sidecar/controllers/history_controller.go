@@ -0,0 +1,104 @@
+/*
+  OCM-DESCRIPTOR-SIDECAR
+  Copyright © 2022-2024 EVIDEN
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+  This work has received funding from the European Union's HORIZON research
+  and innovation programme under grant agreement No. 101070177.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"icos/server/ocm-descriptor-sidecar/responses"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+type HistoryResponse struct {
+	Total   int              `json:"total"`
+	Offset  int              `json:"offset"`
+	Limit   int              `json:"limit"`
+	Results []ScheduleResult `json:"results"`
+}
+
+// History lists the last runs, filtered by the task, outcome, since and until
+// (RFC 3339) query parameters and paginated with offset and limit
+func (server *Server) History(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := HistoryFilter{
+		Task:    query.Get("task"),
+		Outcome: Outcome(query.Get("outcome")),
+	}
+	if filter.Outcome != "" && filter.Outcome != OutcomeSuccess && filter.Outcome != OutcomeFailure {
+		responses.ERROR(w, http.StatusBadRequest, errors.New("outcome must be success or failure"))
+		return
+	}
+	var err error
+	if filter.Since, err = parseTimeParam(query.Get("since")); err != nil {
+		responses.ERROR(w, http.StatusBadRequest, errors.New("since: "+err.Error()))
+		return
+	}
+	if filter.Until, err = parseTimeParam(query.Get("until")); err != nil {
+		responses.ERROR(w, http.StatusBadRequest, errors.New("until: "+err.Error()))
+		return
+	}
+	offset, err := parseIntParam(query.Get("offset"), 0, 0, -1)
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, errors.New("offset: "+err.Error()))
+		return
+	}
+	limit, err := parseIntParam(query.Get("limit"), defaultHistoryLimit, 1, maxHistoryLimit)
+	if err != nil {
+		responses.ERROR(w, http.StatusBadRequest, errors.New("limit: "+err.Error()))
+		return
+	}
+
+	results, total := server.history.Query(filter, offset, limit)
+	responses.JSON(w, http.StatusOK, HistoryResponse{
+		Total:   total,
+		Offset:  offset,
+		Limit:   limit,
+		Results: results,
+	})
+}
+
+func parseTimeParam(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// parseIntParam parses v, defaulting to def and checking it against min and max, a negative max meaning no bound
+func parseIntParam(v string, def, min, max int) (int, error) {
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, err
+	}
+	if n < min || (max >= 0 && n > max) {
+		return 0, errors.New("out of range")
+	}
+	return n, nil
+}
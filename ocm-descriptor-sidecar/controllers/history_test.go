@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistory(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	newHistory := func() *History {
+		h := NewHistory(3)
+		for i, task := range []string{TaskExecute, TaskSync, TaskExecute, TaskSync} {
+			outcome := OutcomeSuccess
+			if i%2 == 1 {
+				outcome = OutcomeFailure
+			}
+			h.Add(ScheduleResult{RunID: string(rune('a' + i)), Task: task, Outcome: outcome, StartedAt: start.Add(time.Duration(i) * time.Minute)})
+		}
+		return h
+	}
+
+	t.Run("should keep the newest results first", func(t *testing.T) {
+		results, total := newHistory().Query(HistoryFilter{}, 0, 10)
+
+		assert.Equal(t, 3, total)
+		assert.Equal(t, []string{"d", "c", "b"}, runIDs(results))
+	})
+
+	t.Run("should filter by task and outcome", func(t *testing.T) {
+		results, total := newHistory().Query(HistoryFilter{Task: TaskSync, Outcome: OutcomeFailure}, 0, 10)
+
+		assert.Equal(t, 2, total)
+		assert.Equal(t, []string{"d", "b"}, runIDs(results))
+	})
+
+	t.Run("should filter by time range", func(t *testing.T) {
+		results, _ := newHistory().Query(HistoryFilter{Since: start.Add(2 * time.Minute), Until: start.Add(3 * time.Minute)}, 0, 10)
+
+		assert.Equal(t, []string{"c"}, runIDs(results))
+	})
+
+	t.Run("should paginate", func(t *testing.T) {
+		results, total := newHistory().Query(HistoryFilter{}, 1, 1)
+
+		assert.Equal(t, 3, total)
+		assert.Equal(t, []string{"c"}, runIDs(results))
+	})
+}
+
+func runIDs(results []ScheduleResult) []string {
+	ids := []string{}
+	for _, result := range results {
+		ids = append(ids, result.RunID)
+	}
+	return ids
+}
@@ -0,0 +1,96 @@
+/*
+  OCM-DESCRIPTOR-SIDECAR
+  Copyright © 2022-2024 EVIDEN
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+  This work has received funding from the European Union's HORIZON research
+  and innovation programme under grant agreement No. 101070177.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"icos/server/ocm-descriptor-sidecar/config"
+	"icos/server/ocm-descriptor-sidecar/utils/leader"
+	"os"
+	"strings"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const namespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// LeaderStatus tells whether this replica is allowed to run the tasks
+type LeaderStatus struct {
+	Enabled  bool   `json:"enabled"`
+	Identity string `json:"identity,omitempty"`
+	Leader   bool   `json:"leader"`
+}
+
+// NewLeaderElector creates the elector of the configured mode, which is either empty
+// (disabled), "lease" for a coordination.k8s.io Lease or "file" for a lock file on a
+// shared volume. It returns nil when leader election is disabled.
+func NewLeaderElector(config config.LeaderElection) (*leader.Elector, error) {
+	mode := strings.ToLower(config.Mode)
+	if mode == "" {
+		return nil, nil
+	}
+
+	identity := config.ID
+	if identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("leader election identity: %w", err)
+		}
+		identity = hostname
+	}
+
+	var lock resourcelock.Interface
+	switch mode {
+	case "lease":
+		restConfig, err := rest.InClusterConfig()
+		if err != nil {
+			return nil, fmt.Errorf("leader election: %w", err)
+		}
+		client, err := kubernetes.NewForConfig(restConfig)
+		if err != nil {
+			return nil, fmt.Errorf("leader election: %w", err)
+		}
+		lock = leader.NewLeaseLock(client, leaseNamespace(config.Namespace), config.LeaseName, identity)
+	case "file":
+		lock = leader.NewFileLock(config.File, identity)
+	default:
+		return nil, fmt.Errorf("leader election: unknown mode %q, expected lease or file", mode)
+	}
+
+	elector, err := leader.NewElector(lock, config.Timings)
+	if err != nil {
+		return nil, fmt.Errorf("leader election: %w", err)
+	}
+	return elector, nil
+}
+
+// leaseNamespace returns the configured namespace, falling back to the namespace of the pod
+func leaseNamespace(namespace string) string {
+	if namespace != "" {
+		return namespace
+	}
+	if ns, err := os.ReadFile(namespaceFile); err == nil {
+		return strings.TrimSpace(string(ns))
+	}
+	return "default"
+}
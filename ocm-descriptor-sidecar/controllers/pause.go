@@ -0,0 +1,171 @@
+/*
+  OCM-DESCRIPTOR-SIDECAR
+  Copyright © 2022-2024 EVIDEN
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+  This work has received funding from the European Union's HORIZON research
+  and innovation programme under grant agreement No. 101070177.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"icos/server/ocm-descriptor-sidecar/utils/metrics"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// selectRunners returns the runner of the named task, or every runner when name is empty
+func (server *Server) selectRunners(name string) ([]*taskRunner, error) {
+	if name == "" {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		return append([]*taskRunner(nil), server.runners...), nil
+	}
+	runner := server.runner(name)
+	if runner == nil {
+		return nil, fmt.Errorf("unknown task %s", name)
+	}
+	return []*taskRunner{runner}, nil
+}
+
+// Pause stops scheduling the named task, or every task when name is empty.
+// In-flight runs are left to complete.
+func (server *Server) Pause(name string) error {
+	runners, err := server.selectRunners(name)
+	if err != nil {
+		return err
+	}
+	for _, runner := range runners {
+		if !runner.paused.Swap(true) {
+			metrics.TaskPaused.WithLabelValues(runner.name()).Set(1)
+			runner.log.Info("task paused")
+		}
+	}
+	return server.savePauseState()
+}
+
+// Resume schedules the named task again, or every task when name is empty
+func (server *Server) Resume(name string) error {
+	runners, err := server.selectRunners(name)
+	if err != nil {
+		return err
+	}
+	for _, runner := range runners {
+		if runner.paused.Swap(false) {
+			runner.mu.Lock()
+			runner.resumedAt = time.Now()
+			runner.mu.Unlock()
+			metrics.TaskPaused.WithLabelValues(runner.name()).Set(0)
+			runner.log.Info("task resumed")
+		}
+	}
+	return server.savePauseState()
+}
+
+// Drain pauses the named task, or every task when name is empty, and waits until
+// their in-flight runs are over or ctx is cancelled
+func (server *Server) Drain(ctx context.Context, name string) error {
+	if err := server.Pause(name); err != nil {
+		return err
+	}
+	runners, err := server.selectRunners(name)
+	if err != nil {
+		return err
+	}
+	for _, runner := range runners {
+		if err := runner.waitIdle(ctx); err != nil {
+			return fmt.Errorf("draining %s: %w", runner.name(), err)
+		}
+	}
+	logger.Info("drained " + describeSelection(name))
+	return nil
+}
+
+// PausedTasks returns the names of the paused tasks
+func (server *Server) PausedTasks() []string {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	paused := []string{}
+	for _, runner := range server.runners {
+		if runner.paused.Load() {
+			paused = append(paused, runner.name())
+		}
+	}
+	sort.Strings(paused)
+	return paused
+}
+
+// savePauseState writes the paused tasks to the pause state file, if one is configured.
+// The snapshot is taken under the lock, so that the last write holds the latest state.
+func (server *Server) savePauseState() error {
+	if server.pauseStateFile == "" {
+		return nil
+	}
+	server.pauseStateMu.Lock()
+	defer server.pauseStateMu.Unlock()
+
+	b, err := json.Marshal(server.PausedTasks())
+	if err != nil {
+		return err
+	}
+	tmp := server.pauseStateFile + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("saving pause state: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Clean(server.pauseStateFile)); err != nil {
+		return fmt.Errorf("saving pause state: %w", err)
+	}
+	return nil
+}
+
+// loadPauseState pauses the tasks listed in the pause state file, if one is configured
+func (server *Server) loadPauseState() error {
+	if server.pauseStateFile == "" {
+		return nil
+	}
+	b, err := os.ReadFile(server.pauseStateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("loading pause state: %w", err)
+	}
+	var paused []string
+	if err := json.Unmarshal(b, &paused); err != nil {
+		return fmt.Errorf("loading pause state %s: %w", server.pauseStateFile, err)
+	}
+	for _, name := range paused {
+		if runner := server.runner(name); runner != nil {
+			runner.paused.Store(true)
+			metrics.TaskPaused.WithLabelValues(name).Set(1)
+			runner.log.Info("task paused from the saved state")
+		}
+	}
+	return nil
+}
+
+func describeSelection(name string) string {
+	if name == "" {
+		return "all tasks"
+	}
+	return "task " + name
+}
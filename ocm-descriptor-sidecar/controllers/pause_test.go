@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPause(t *testing.T) {
+	newServer := func(pauseStateFile string) (*Server, chan struct{}) {
+		runner, release := blockingRunner(ForbidConcurrent)
+		runner.task.Name = TaskExecute
+		return &Server{pauseStateFile: pauseStateFile, runners: []*taskRunner{runner, newTaskRunner(&Task{Name: TaskSync})}}, release
+	}
+
+	t.Run("should pause and resume a single task", func(t *testing.T) {
+		server, _ := newServer("")
+
+		assert.NoError(t, server.Pause(TaskSync))
+		assert.Equal(t, []string{TaskSync}, server.PausedTasks())
+		_, _, err := server.runner(TaskSync).trigger(context.Background())
+		assert.ErrorIs(t, err, ErrPaused)
+
+		assert.NoError(t, server.Resume(""))
+		assert.Empty(t, server.PausedTasks())
+	})
+
+	t.Run("should return error for unknown tasks", func(t *testing.T) {
+		server, _ := newServer("")
+
+		assert.Error(t, server.Pause("deploy"))
+	})
+
+	t.Run("should wait for in-flight runs when draining", func(t *testing.T) {
+		server, release := newServer("")
+		_, _, err := server.runner(TaskExecute).trigger(context.Background())
+		assert.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, server.Drain(ctx, ""), context.DeadlineExceeded)
+
+		close(release)
+		assert.NoError(t, server.Drain(context.Background(), ""))
+		assert.Equal(t, []string{TaskExecute, TaskSync}, server.PausedTasks())
+	})
+
+	t.Run("should not start a run triggered while draining", func(t *testing.T) {
+		server, release := newServer("")
+		defer close(release)
+		runner := server.runner(TaskExecute)
+		// the drain happens after the trigger checked the pause flag, before it registers the run
+		runner.canRun = func() bool {
+			assert.NoError(t, server.Drain(context.Background(), TaskExecute))
+			return true
+		}
+
+		_, _, err := runner.trigger(context.Background())
+
+		assert.ErrorIs(t, err, ErrPaused)
+		assert.Equal(t, uint64(0), runner.Status().Runs)
+	})
+
+	t.Run("should keep the pause state across restarts", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "paused.json")
+		server, _ := newServer(path)
+		assert.NoError(t, server.Pause(TaskExecute))
+
+		restarted, _ := newServer(path)
+		assert.NoError(t, restarted.loadPauseState())
+
+		assert.Equal(t, []string{TaskExecute}, restarted.PausedTasks())
+	})
+
+	t.Run("should save the latest pause state on concurrent calls", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "paused.json")
+		server, _ := newServer(path)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, server.Pause(TaskExecute))
+			}()
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, server.Resume(TaskSync))
+			}()
+		}
+		wg.Wait()
+
+		restarted, _ := newServer(path)
+		assert.NoError(t, restarted.loadPauseState())
+		assert.Equal(t, []string{TaskExecute}, restarted.PausedTasks())
+	})
+}
@@ -0,0 +1,67 @@
+/*
+  OCM-DESCRIPTOR-SIDECAR
+  Copyright © 2022-2024 EVIDEN
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+  This work has received funding from the European Union's HORIZON research
+  and innovation programme under grant agreement No. 101070177.
+*/
+
+package controllers
+
+import (
+	"icos/server/ocm-descriptor-sidecar/config"
+	"icos/server/ocm-descriptor-sidecar/models"
+)
+
+// Reload applies a new configuration to the running scheduler. The Deployment Manager
+// settings and the schedules apply from the next run on, the in-flight runs complete
+// with the settings they started with. The server, leader election, Lighthouse and
+// matchmaking settings are only read at startup.
+func (server *Server) Reload(config *config.Config) error {
+	tasks, err := NewTasks(config.Tasks)
+	if err != nil {
+		return err
+	}
+	// the credentials are in place before the requester that uses them
+	models.Configure(config.Keycloak)
+	models.InvalidateTokenCache()
+	configureDeployManager(config.DeployManager, models.NewTokenRequester(config.Auth))
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	old := server.config
+	if old != nil {
+		if old.Server != config.Server {
+			logger.Warn("server settings changed, they require a restart")
+		}
+		if old.LeaderElection != config.LeaderElection {
+			logger.Warn("leader election settings changed, they require a restart")
+		}
+		if old.LighthouseURL != config.LighthouseURL || old.MatchmakingURL != config.MatchmakingURL {
+			logger.Warn("lighthouse and matchmaking URLs changed, they require a restart")
+		}
+	}
+	server.config = config
+	server.tasks = tasks
+	for _, task := range tasks {
+		for _, runner := range server.runners {
+			if runner.name() == task.Name {
+				runner.setTask(task)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+/*
+  OCM-DESCRIPTOR-SIDECAR
+  Copyright © 2022-2024 EVIDEN
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+  This work has received funding from the European Union's HORIZON research
+  and innovation programme under grant agreement No. 101070177.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"icos/server/ocm-descriptor-sidecar/models"
+	"icos/server/ocm-descriptor-sidecar/utils/breaker"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ErrorClass groups the failures of a run by cause
+type ErrorClass string
+
+const (
+	ErrorNone        ErrorClass = ""
+	ErrorInternal    ErrorClass = "internal"
+	ErrorAuth        ErrorClass = "auth"
+	ErrorNetwork     ErrorClass = "network"
+	ErrorTimeout     ErrorClass = "timeout"
+	ErrorRateLimited ErrorClass = "rate_limited"
+	ErrorServer      ErrorClass = "server_error"
+	ErrorClient      ErrorClass = "client_error"
+	ErrorCircuitOpen ErrorClass = "circuit_open"
+	ErrorCancelled   ErrorClass = "cancelled"
+)
+
+// Outcome is the overall result of a run
+type Outcome string
+
+const (
+	OutcomeSuccess Outcome = "success"
+	OutcomeFailure Outcome = "failure"
+)
+
+// ScheduleResult describes a run of a task against the Deployment Manager
+type ScheduleResult struct {
+	RunID       string             `json:"run_id"`
+	Task        string             `json:"task"`
+	Endpoint    string             `json:"endpoint"`
+	StartedAt   time.Time          `json:"started_at"`
+	Duration    time.Duration      `json:"-"`
+	DurationMs  int64              `json:"duration_ms"`
+	Outcome     Outcome            `json:"outcome"`
+	StatusCode  int                `json:"status_code,omitempty"`
+	Status      string             `json:"status,omitempty"`
+	Bytes       int64              `json:"bytes"`
+	Attempts    int                `json:"attempts"`
+	TokenSource models.TokenSource `json:"token_source,omitempty"`
+	ErrorClass  ErrorClass         `json:"error_class,omitempty"`
+	Error       string             `json:"error,omitempty"`
+
+	// Err is the error of the last attempt, nil on success
+	Err error `json:"-"`
+}
+
+func (result ScheduleResult) Succeeded() bool {
+	return result.Outcome == OutcomeSuccess
+}
+
+func (result ScheduleResult) String() string {
+	if result.Succeeded() {
+		return fmt.Sprintf("run %s of %s: %s, %d bytes in %s after %d attempt(s), %s token",
+			result.RunID, result.Task, result.Status, result.Bytes, result.Duration.Round(time.Millisecond), result.Attempts, result.TokenSource)
+	}
+	return fmt.Sprintf("run %s of %s failed (%s) in %s after %d attempt(s): %s",
+		result.RunID, result.Task, result.ErrorClass, result.Duration.Round(time.Millisecond), result.Attempts, result.Error)
+}
+
+// logArgs returns the result as key-value pairs for the structured logs
+func (result ScheduleResult) logArgs() []any {
+	args := []any{
+		"run_id", result.RunID,
+		"outcome", result.Outcome,
+		"duration", result.Duration.Round(time.Millisecond),
+		"attempts", result.Attempts,
+	}
+	if result.StatusCode != 0 {
+		args = append(args, "status_code", result.StatusCode, "bytes", result.Bytes)
+	}
+	if result.TokenSource != "" {
+		args = append(args, "token_source", result.TokenSource)
+	}
+	if !result.Succeeded() {
+		args = append(args, "error_class", result.ErrorClass, "error", result.Error)
+	}
+	return args
+}
+
+// finish sets the outcome of the result from the error of its last attempt
+func (result *ScheduleResult) finish(err error) {
+	result.Duration = time.Since(result.StartedAt)
+	result.DurationMs = result.Duration.Milliseconds()
+	result.Err = err
+	if err == nil {
+		result.Outcome = OutcomeSuccess
+		return
+	}
+	result.Outcome = OutcomeFailure
+	result.ErrorClass = classifyError(err)
+	result.Error = err.Error()
+}
+
+// tokenError wraps a failure to obtain a token for the Deployment Manager
+type tokenError struct {
+	err error
+}
+
+func (e *tokenError) Error() string { return "fetching token: " + e.err.Error() }
+func (e *tokenError) Unwrap() error { return e.err }
+
+// classifyError returns the class of the error of a run
+func classifyError(err error) ErrorClass {
+	var statusErr *StatusError
+	var tokenErr *tokenError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return ErrorNone
+	case errors.Is(err, context.Canceled):
+		return ErrorCancelled
+	case errors.Is(err, breaker.ErrOpen):
+		return ErrorCircuitOpen
+	case errors.As(err, &tokenErr):
+		return ErrorAuth
+	case errors.As(err, &statusErr):
+		switch {
+		case statusErr.StatusCode == http.StatusTooManyRequests:
+			return ErrorRateLimited
+		case statusErr.StatusCode >= 500:
+			return ErrorServer
+		}
+		return ErrorClient
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorTimeout
+	case errors.As(err, &netErr):
+		if netErr.Timeout() {
+			return ErrorTimeout
+		}
+		return ErrorNetwork
+	}
+	return ErrorInternal
+}
+
+// newRunID returns a random identifier for a run
+func newRunID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
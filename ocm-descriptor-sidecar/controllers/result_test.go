@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"icos/server/ocm-descriptor-sidecar/utils/breaker"
+	"icos/server/ocm-descriptor-sidecar/utils/retry"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyError(t *testing.T) {
+	cases := map[ErrorClass]error{
+		ErrorNone:        nil,
+		ErrorCancelled:   fmt.Errorf("get: %w", context.Canceled),
+		ErrorCircuitOpen: breaker.ErrOpen,
+		ErrorAuth:        retry.Retryable(&tokenError{err: errors.New("invalid_client")}, 0),
+		ErrorRateLimited: retry.Retryable(&StatusError{StatusCode: http.StatusTooManyRequests}, time.Second),
+		ErrorServer:      retry.Retryable(&StatusError{StatusCode: http.StatusBadGateway}, 0),
+		ErrorClient:      &StatusError{StatusCode: http.StatusNotFound},
+		ErrorTimeout:     context.DeadlineExceeded,
+		ErrorInternal:    errors.New("unexpected"),
+	}
+	for class, err := range cases {
+		assert.Equal(t, class, classifyError(err), "error %v", err)
+	}
+}
+
+func TestScheduleResultFinish(t *testing.T) {
+	t.Run("should succeed without error", func(t *testing.T) {
+		result := ScheduleResult{StartedAt: time.Now()}
+		result.finish(nil)
+
+		assert.True(t, result.Succeeded())
+		assert.Empty(t, result.ErrorClass)
+	})
+
+	t.Run("should record the error class", func(t *testing.T) {
+		result := ScheduleResult{StartedAt: time.Now()}
+		result.finish(&StatusError{StatusCode: http.StatusForbidden, Status: "403 Forbidden"})
+
+		assert.False(t, result.Succeeded())
+		assert.Equal(t, ErrorClient, result.ErrorClass)
+		assert.Equal(t, "deployment manager returned 403 Forbidden", result.Error)
+	})
+}
@@ -0,0 +1,55 @@
+/*
+  OCM-DESCRIPTOR-SIDECAR
+  Copyright © 2022-2024 EVIDEN
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+  This work has received funding from the European Union's HORIZON research
+  and innovation programme under grant agreement No. 101070177.
+*/
+
+package controllers
+
+import (
+	"icos/server/ocm-descriptor-sidecar/middlewares"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+func (server *Server) initializeRoutes() {
+	// Home Route
+	server.Router.HandleFunc("/", middlewares.SetMiddlewareLog(middlewares.SetMiddlewareJSON(server.Home))).Methods("GET")
+
+	// Health Routes, not logged as they are polled by the kubelet
+	server.Router.HandleFunc("/healthz", middlewares.SetMiddlewareJSON(server.Healthz)).Methods("GET")
+	server.Router.HandleFunc("/readyz", middlewares.SetMiddlewareJSON(server.Readyz)).Methods("GET")
+
+	// Metrics Route, in the Prometheus exposition format
+	server.Router.Handle("/metrics", promhttp.Handler()).Methods("GET")
+
+	// Status Route
+	server.Router.HandleFunc("/status", middlewares.SetMiddlewareLog(middlewares.SetMiddlewareJSON(server.Status))).Methods("GET")
+
+	// Trigger Routes
+	server.Router.HandleFunc("/trigger/{task}", middlewares.SetMiddlewareLog(middlewares.SetMiddlewareJSON(middlewares.JWTValidation(server.Trigger)))).Methods("POST")
+	server.Router.HandleFunc("/runs/{id}", middlewares.SetMiddlewareLog(middlewares.SetMiddlewareJSON(middlewares.JWTValidation(server.GetRun)))).Methods("GET")
+
+	// Admin Routes
+	server.Router.HandleFunc("/admin/pause", middlewares.SetMiddlewareLog(middlewares.SetMiddlewareJSON(middlewares.JWTValidation(server.AdminPause)))).Methods("POST")
+	server.Router.HandleFunc("/admin/resume", middlewares.SetMiddlewareLog(middlewares.SetMiddlewareJSON(middlewares.JWTValidation(server.AdminResume)))).Methods("POST")
+	server.Router.HandleFunc("/admin/drain", middlewares.SetMiddlewareLog(middlewares.SetMiddlewareJSON(middlewares.JWTValidation(server.AdminDrain)))).Methods("POST")
+	server.Router.HandleFunc("/admin/log-level", middlewares.SetMiddlewareLog(middlewares.SetMiddlewareJSON(middlewares.JWTValidation(server.AdminLogLevel)))).Methods("GET", "POST")
+
+	// History Route
+	server.Router.HandleFunc("/history", middlewares.SetMiddlewareLog(middlewares.SetMiddlewareJSON(server.History))).Methods("GET")
+}
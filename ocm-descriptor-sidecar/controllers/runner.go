@@ -0,0 +1,346 @@
+/*
+  OCM-DESCRIPTOR-SIDECAR
+  Copyright © 2022-2024 EVIDEN
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+  This work has received funding from the European Union's HORIZON research
+  and innovation programme under grant agreement No. 101070177.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"icos/server/ocm-descriptor-sidecar/utils/metrics"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// delayTolerance is how late a tick may fire before it is reported as delayed
+const delayTolerance = time.Second
+
+var (
+	// ErrRunInProgress is returned when the concurrency policy forbids a new run
+	ErrRunInProgress = errors.New("previous run still in progress")
+	// ErrPaused is returned when a run of a paused task is requested
+	ErrPaused = errors.New("task is paused")
+	// ErrNotLeader is returned when a run is requested on a leader election follower
+	ErrNotLeader = errors.New("this replica is not the leader")
+	// ErrStopped is returned when a run is requested once the scheduler is stopping
+	ErrStopped = errors.New("scheduler is stopping")
+)
+
+// TaskStats counts what happened to the ticks of a task
+type TaskStats struct {
+	Runs     uint64 `json:"runs"`
+	Skipped  uint64 `json:"skipped"`
+	Delayed  uint64 `json:"delayed"`
+	Replaced uint64 `json:"replaced"`
+	Running  int    `json:"running"`
+}
+
+type TaskStatus struct {
+	Name              string            `json:"name"`
+	Paused            bool              `json:"paused"`
+	Schedule          string            `json:"schedule"`
+	ConcurrencyPolicy ConcurrencyPolicy `json:"concurrency_policy"`
+	TaskStats
+	LastRun     *ScheduleResult `json:"last_run,omitempty"`
+	LastSuccess *time.Time      `json:"last_success,omitempty"`
+}
+
+var skipReasons = map[string]string{
+	"in_progress": "previous run still in progress",
+	"missed":      "missed while the scheduler was late",
+}
+
+// taskRunner fires a task on its schedule and applies its concurrency policy
+type taskRunner struct {
+	// task is replaced as a whole by setTask, it is read with currentTask
+	task *Task
+	log  *slog.Logger
+	run  func(ctx context.Context, task *Task, runID string) ScheduleResult
+	// canRun tells whether this replica may run the task, it is false on leader election followers
+	canRun func() bool
+	// record receives the result of every run
+	record func(ScheduleResult)
+	// runs tracks the in-flight runs so that the runner can wait for them
+	runs   sync.WaitGroup
+	paused atomic.Bool
+
+	mu sync.Mutex
+	// closed is set once the loop is over, no run may start after it
+	closed bool
+	// cancels holds the cancel function of every in-flight run by run ID
+	cancels map[string]context.CancelFunc
+	stats   TaskStats
+	lastRun *ScheduleResult
+	// lastSuccess is the start time of the last successful run
+	lastSuccess time.Time
+	// resumedAt is when the task was last resumed after a pause
+	resumedAt time.Time
+	// idle is closed once the in-flight runs are over, it is only created while waiting for them
+	idle chan struct{}
+	// rescheduled tells the loop that the schedule of the task changed
+	rescheduled chan struct{}
+}
+
+func newTaskRunner(task *Task) *taskRunner {
+	return &taskRunner{
+		task:        task,
+		log:         logger.With("task", task.Name),
+		run:         scheduleRun,
+		canRun:      func() bool { return true },
+		record:      func(ScheduleResult) {},
+		cancels:     make(map[string]context.CancelFunc),
+		rescheduled: make(chan struct{}, 1),
+	}
+}
+
+// currentTask returns the task with its current schedule
+func (r *taskRunner) currentTask() *Task {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.task
+}
+
+// name returns the name of the task, which never changes
+func (r *taskRunner) name() string {
+	return r.currentTask().Name
+}
+
+// setTask replaces the schedule of the task from the next tick on, the in-flight runs
+// keep the task they started with. It reports whether the schedule changed.
+func (r *taskRunner) setTask(task *Task) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old := r.task
+	if task.Interval == old.Interval && task.Cron == old.Cron && task.InitialDelay == old.InitialDelay &&
+		task.RunAtStartup == old.RunAtStartup && task.ConcurrencyPolicy == old.ConcurrencyPolicy {
+		return false
+	}
+	r.task = task
+	select {
+	case r.rescheduled <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// loop fires the task until ctx is cancelled and returns once its in-flight runs,
+// which use runCtx, are over
+func (r *taskRunner) loop(ctx, runCtx context.Context) {
+	defer r.stop()
+
+	task := r.currentTask()
+	r.log.Info("scheduling task", "schedule", task.String())
+	next := task.firstRun(time.Now())
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			now := time.Now()
+			if late := now.Sub(next); late > delayTolerance {
+				r.delayed(late)
+			}
+			if r.canRun() && !r.paused.Load() {
+				if _, _, err := r.trigger(runCtx); errors.Is(err, ErrRunInProgress) {
+					r.skipped(1, "in_progress")
+				}
+			}
+
+			// skip the activations missed while the tick was late
+			missed := 0
+			next = task.next(next)
+			for !next.After(now) {
+				missed++
+				next = task.next(next)
+			}
+			if missed > 0 {
+				r.skipped(missed, "missed")
+			}
+			timer.Reset(time.Until(next))
+		case <-r.rescheduled:
+			task = r.currentTask()
+			r.log.Info("schedule changed", "schedule", task.String())
+			next = task.next(time.Now())
+			timer.Reset(time.Until(next))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// stop refuses the new runs and waits for the in-flight ones
+func (r *taskRunner) stop() {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+	r.runs.Wait()
+}
+
+// trigger starts a run of the task unless the replica is not the leader, the runner is
+// stopped or the concurrency policy forbids it. It returns the ID of the run and a
+// channel receiving its result once it is over.
+func (r *taskRunner) trigger(runCtx context.Context) (string, <-chan ScheduleResult, error) {
+	if r.paused.Load() {
+		return "", nil, ErrPaused
+	}
+	if !r.canRun() {
+		return "", nil, ErrNotLeader
+	}
+	r.mu.Lock()
+	// checked under the lock, so that a run cannot be added once stop or a drain waits for them
+	if r.closed {
+		r.mu.Unlock()
+		return "", nil, ErrStopped
+	}
+	if r.paused.Load() {
+		r.mu.Unlock()
+		return "", nil, ErrPaused
+	}
+	task := r.task
+	if len(r.cancels) > 0 {
+		switch task.ConcurrencyPolicy {
+		case ForbidConcurrent:
+			r.mu.Unlock()
+			return "", nil, ErrRunInProgress
+		case ReplaceConcurrent:
+			for _, cancel := range r.cancels {
+				cancel()
+			}
+			r.stats.Replaced++
+			metrics.TaskRunsReplaced.WithLabelValues(task.Name).Inc()
+			r.log.Warn("replacing the in-flight run")
+		}
+	}
+	ctx, cancel := context.WithCancel(runCtx)
+	runID := newRunID()
+	r.cancels[runID] = cancel
+	r.stats.Runs++
+	r.runs.Add(1)
+	r.mu.Unlock()
+
+	done := make(chan ScheduleResult, 1)
+	go func() {
+		defer r.runs.Done()
+		defer r.finish(runID)
+
+		result := r.run(ctx, task, runID)
+		metrics.TaskRuns.WithLabelValues(task.Name, string(result.Outcome)).Inc()
+		metrics.TaskRunDuration.WithLabelValues(task.Name, string(result.Outcome)).Observe(result.Duration.Seconds())
+		if result.Succeeded() {
+			r.log.Info("run finished", result.logArgs()...)
+		} else {
+			r.log.Error("run failed", result.logArgs()...)
+		}
+		r.mu.Lock()
+		r.lastRun = &result
+		if result.Succeeded() {
+			r.lastSuccess = result.StartedAt
+		}
+		r.mu.Unlock()
+		r.record(result)
+		done <- result
+	}()
+	return runID, done, nil
+}
+
+func (r *taskRunner) finish(runID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cancels[runID]()
+	delete(r.cancels, runID)
+	if len(r.cancels) == 0 && r.idle != nil {
+		close(r.idle)
+		r.idle = nil
+	}
+}
+
+// waitIdle waits until no run of the task is in progress or ctx is cancelled
+func (r *taskRunner) waitIdle(ctx context.Context) error {
+	r.mu.Lock()
+	if len(r.cancels) == 0 {
+		r.mu.Unlock()
+		return nil
+	}
+	if r.idle == nil {
+		r.idle = make(chan struct{})
+	}
+	idle := r.idle
+	r.mu.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// running reports whether the given run is in progress
+func (r *taskRunner) running(runID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.cancels[runID]
+	return ok
+}
+
+// skipped records ticks that did not start a run, reason being either in_progress
+// when the concurrency policy forbade it or missed when the scheduler was late
+func (r *taskRunner) skipped(n int, reason string) {
+	r.mu.Lock()
+	r.stats.Skipped += uint64(n)
+	r.mu.Unlock()
+	metrics.TaskTicksSkipped.WithLabelValues(r.name(), reason).Add(float64(n))
+	r.log.Warn("ticks skipped", "count", n, "reason", skipReasons[reason])
+}
+
+func (r *taskRunner) delayed(late time.Duration) {
+	r.mu.Lock()
+	r.stats.Delayed++
+	r.mu.Unlock()
+	metrics.TaskTicksDelayed.WithLabelValues(r.name()).Inc()
+	r.log.Warn("tick delayed", "late", late.Round(time.Millisecond))
+}
+
+// Status returns a snapshot of the task and its counters
+func (r *taskRunner) Status() TaskStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := r.stats
+	stats.Running = len(r.cancels)
+	status := TaskStatus{
+		Name:              r.task.Name,
+		Paused:            r.paused.Load(),
+		Schedule:          r.task.String(),
+		ConcurrencyPolicy: r.task.ConcurrencyPolicy,
+		TaskStats:         stats,
+		LastRun:           r.lastRun,
+	}
+	if !r.lastSuccess.IsZero() {
+		lastSuccess := r.lastSuccess
+		status.LastSuccess = &lastSuccess
+	}
+	return status
+}
@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingRunner returns a runner whose runs block until released or cancelled
+func blockingRunner(policy ConcurrencyPolicy) (*taskRunner, chan struct{}) {
+	release := make(chan struct{})
+	runner := newTaskRunner(&Task{Name: "test", ConcurrencyPolicy: policy})
+	runner.log = slog.New(slog.DiscardHandler)
+	runner.run = func(ctx context.Context, task *Task, runID string) ScheduleResult {
+		result := ScheduleResult{RunID: runID, Task: task.Name, StartedAt: time.Now()}
+		select {
+		case <-release:
+			result.finish(nil)
+		case <-ctx.Done():
+			result.finish(ctx.Err())
+		}
+		return result
+	}
+	return runner, release
+}
+
+func TestTaskRunnerTrigger(t *testing.T) {
+	t.Run("should refuse run when policy is Forbid", func(t *testing.T) {
+		runner, release := blockingRunner(ForbidConcurrent)
+		runID, done, err := runner.trigger(context.Background())
+		assert.NoError(t, err)
+		_, _, err = runner.trigger(context.Background())
+		assert.ErrorIs(t, err, ErrRunInProgress)
+
+		status := runner.Status()
+		assert.Equal(t, uint64(1), status.Runs)
+		assert.Equal(t, 1, status.Running)
+		assert.True(t, runner.running(runID))
+
+		close(release)
+		result := <-done
+		assert.Equal(t, runID, result.RunID)
+		assert.True(t, result.Succeeded())
+		runner.runs.Wait()
+		assert.Equal(t, 0, runner.Status().Running)
+	})
+
+	t.Run("should run concurrently when policy is Allow", func(t *testing.T) {
+		runner, release := blockingRunner(AllowConcurrent)
+		runner.trigger(context.Background())
+		runner.trigger(context.Background())
+
+		status := runner.Status()
+		assert.Equal(t, uint64(2), status.Runs)
+		assert.Equal(t, 2, status.Running)
+
+		close(release)
+		runner.runs.Wait()
+	})
+
+	t.Run("should cancel previous run when policy is Replace", func(t *testing.T) {
+		runner, release := blockingRunner(ReplaceConcurrent)
+		runner.trigger(context.Background())
+		runner.trigger(context.Background())
+
+		status := runner.Status()
+		assert.Equal(t, uint64(2), status.Runs)
+		assert.Equal(t, uint64(1), status.Replaced)
+
+		close(release)
+		runner.runs.Wait()
+	})
+}
+
+func TestTaskRunnerSetTask(t *testing.T) {
+	t.Run("should keep the in-flight run when the schedule changes", func(t *testing.T) {
+		runner, release := blockingRunner(ForbidConcurrent)
+		_, done, err := runner.trigger(context.Background())
+		assert.NoError(t, err)
+
+		changed := runner.setTask(&Task{Name: "test", Interval: time.Minute, ConcurrencyPolicy: ReplaceConcurrent})
+		assert.True(t, changed)
+		assert.Equal(t, ReplaceConcurrent, runner.Status().ConcurrencyPolicy)
+		assert.Equal(t, 1, runner.Status().Running)
+
+		close(release)
+		result := <-done
+		assert.True(t, result.Succeeded())
+		runner.runs.Wait()
+	})
+
+	t.Run("should not reschedule when the schedule is the same", func(t *testing.T) {
+		runner, _ := blockingRunner(ForbidConcurrent)
+		assert.False(t, runner.setTask(&Task{Name: "test", ConcurrencyPolicy: ForbidConcurrent}))
+		assert.Len(t, runner.rescheduled, 0)
+	})
+}
@@ -21,89 +21,152 @@
 package controllers
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"icos/server/ocm-descriptor-sidecar/models"
 	"icos/server/ocm-descriptor-sidecar/utils/logs"
-	"log"
+	"icos/server/ocm-descriptor-sidecar/utils/metrics"
+	"icos/server/ocm-descriptor-sidecar/utils/retry"
+	"icos/server/ocm-descriptor-sidecar/utils/tracing"
+	"io"
+	"log/slog"
 	"net/http"
-	"net/http/httputil"
-	"os"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// set by Server.Init from the configuration
 var (
-	deployManagerURL   = os.Getenv("DEPLOY_MANAGER_URL")
-	lighthouseBaseURL  = os.Getenv("LIGHTHOUSE_BASE_URL")
+	lighthouseBaseURL  string
 	apiV3              = "/api/v3"
-	matchmackerBaseURL = os.Getenv("MATCHMAKING_URL")
+	matchmackerBaseURL string
 )
 
-func Schedule() (execStatus string, err error) {
-	logs.Logger.Println("Scheduling Started")
+// Schedule triggers the Deployment Manager endpoint of the given task, retrying
+// transient failures according to the retry policy
+func Schedule(ctx context.Context, task *Task) ScheduleResult {
+	return scheduleRun(ctx, task, newRunID())
+}
 
-	// ------------------------- trigger the execution of the jobs -------------------------
-	reqExecution, err := http.NewRequest("GET", deployManagerURL+"/execute", http.NoBody)
-	if err != nil {
-		logs.Logger.Println("ERROR " + err.Error())
-		return
-	}
-	// get token from keycloak
-	requester := models.KeycloakTokenRequester{}
-	token, err := models.FetchKeycloakToken(requester)
-
-	reqExecution.Header.Add("Authorization", "Bearer "+token.AccessToken)
-	// debug
-	logs.Logger.Println("Execution Request to send to Deployment Manager: ")
-	b, err := httputil.DumpRequest(reqExecution, true)
-	if err != nil {
-		log.Fatalln(err)
-	}
-	fmt.Println(string(b))
-	// do request
-	client := &http.Client{}
-	respExecution, err := client.Do(reqExecution)
-	if err != nil {
-		logs.Logger.Println("ERROR " + err.Error())
-		return
+// scheduleRun runs Schedule under the given run ID
+func scheduleRun(ctx context.Context, task *Task, runID string) ScheduleResult {
+	result := ScheduleResult{
+		RunID:     runID,
+		Task:      task.Name,
+		Endpoint:  task.Path,
+		StartedAt: time.Now(),
 	}
-	defer respExecution.Body.Close()
+	ctx = logs.WithFields(ctx, "task", task.Name, "run_id", runID)
+	log := logs.FromContext(ctx, logger).With("upstream", "deploy-manager")
+	log.Info("scheduling started")
 
-	logs.Logger.Println("Execution Response Info: ")
-	b2, err := httputil.DumpResponse(respExecution, true)
-	if err != nil {
-		log.Fatalln(err)
-	}
-	fmt.Println(string(b2))
+	ctx, span := tracing.Tracer().Start(ctx, "schedule "+task.Name, trace.WithAttributes(
+		attribute.String("task", task.Name),
+		attribute.String("run_id", runID),
+	))
+	defer span.End()
+
+	dm := deployManager.Load()
+	attempts, err := dm.retry.Do(ctx, func(attempt int) error {
+		if attempt > 1 {
+			log.Info("retrying", "attempt", attempt, "max_attempts", dm.retry.MaxAttempts)
+		}
+		return dm.call(ctx, log, task, &result)
+	})
+	result.Attempts = attempts
+	result.finish(err)
 
-	// ------------------------- trigger the sync of the resources -------------------------
-	// update status of all deployed resources into JM periodically
-	reqSync, err := http.NewRequest("GET", deployManagerURL+"/resource/sync", http.NoBody)
+	span.SetAttributes(
+		attribute.Int("attempts", result.Attempts),
+		attribute.String("outcome", string(result.Outcome)),
+	)
 	if err != nil {
-		logs.Logger.Println("ERROR " + err.Error())
-		return
+		span.SetAttributes(attribute.String("error_class", string(result.ErrorClass)))
+		tracing.RecordError(span, err)
 	}
-	reqSync.Header.Add("Authorization", "Bearer "+token.AccessToken)
-	// debug
-	logs.Logger.Println("Sync Request to send to Deployment Manager: ")
-	b3, err := httputil.DumpRequest(reqSync, true)
-	if err != nil {
-		log.Fatalln(err)
+	return result
+}
+
+// call makes a single authenticated call to the Deployment Manager endpoint of the
+// task and records the response in result
+func (dm *deployManagerClient) call(ctx context.Context, log *slog.Logger, task *Task, result *ScheduleResult) error {
+	// fail fast without building requests or fetching tokens while the upstream is known to be down
+	if err := dm.breaker.Allow(); err != nil {
+		return err
 	}
-	fmt.Println(string(b3))
-	// do request
-	respSync, err := client.Do(reqSync)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", dm.url+task.Path, http.NoBody)
 	if err != nil {
-		logs.Logger.Println("ERROR " + err.Error())
-		return
+		dm.breaker.Release()
+		log.Error("cannot build the request", "error", err)
+		return err
 	}
-	defer respExecution.Body.Close()
+	requester := dm.requester
+	// a token rejected by the Deployment Manager, e.g. after its session was revoked,
+	// is evicted and the call made once more with a new one
+	for attempt := 1; ; attempt++ {
+		token, source, err := models.FetchToken(ctx, requester)
+		if err != nil {
+			dm.breaker.Release()
+			log.Error("cannot get a token", "error", err)
+			// rejected credentials do not get better by asking again
+			var oauthErr *models.OAuth2Error
+			if errors.As(err, &oauthErr) && !oauthErr.Temporary() {
+				return &tokenError{err: err}
+			}
+			return retry.Retryable(&tokenError{err: err}, 0)
+		}
+		result.TokenSource = source
 
-	logs.Logger.Println("Sync Response Info: ")
-	b4, err := httputil.DumpResponse(respSync, true)
-	if err != nil {
-		log.Fatalln(err)
+		resp, err := dm.send(ctx, log, req, task, token)
+		if err != nil {
+			log.Error("request to the deployment manager failed", "error", err)
+			err = classifyResponse(nil, err)
+			dm.recordOutcome(err)
+			return err
+		}
+		if attempt == 1 && token.AccessToken != "" && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			log.Warn("token rejected by the deployment manager, retrying with a new token", "status_code", resp.StatusCode)
+			models.EvictToken(ctx, requester, token, resp.StatusCode)
+			continue
+		}
+		defer resp.Body.Close()
+
+		result.StatusCode = resp.StatusCode
+		result.Status = resp.Status
+		result.Bytes, _ = io.Copy(io.Discard, resp.Body)
+
+		err = classifyResponse(resp, nil)
+		dm.recordOutcome(err)
+		return err
 	}
-	fmt.Println(string(b4))
+}
 
-	return respExecution.Status, err
+// send sends a copy of req authenticated with token, if any
+func (dm *deployManagerClient) send(ctx context.Context, log *slog.Logger, req *http.Request, task *Task, token models.JWT) (*http.Response, error) {
+	req = req.Clone(ctx)
+	if token.AccessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	}
+	req, span := tracing.StartClientSpan(req, "GET "+task.Path)
+	logs.DebugRequest(log, "request to the deployment manager", req)
 
+	client := &http.Client{}
+	start := time.Now()
+	resp, err := client.Do(req)
+	code := "0"
+	if resp != nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	metrics.UpstreamRequestDuration.WithLabelValues(task.Path, code).Observe(time.Since(start).Seconds())
+	tracing.EndClientSpan(span, resp, err)
+	if err == nil {
+		logs.DebugResponse(ctx, log, "response from the deployment manager", resp)
+	}
+	return resp, err
 }
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"icos/server/ocm-descriptor-sidecar/config"
+	"icos/server/ocm-descriptor-sidecar/models"
+	"icos/server/ocm-descriptor-sidecar/utils/breaker"
+	"icos/server/ocm-descriptor-sidecar/utils/metrics"
+	"icos/server/ocm-descriptor-sidecar/utils/retry"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// upstreams serves the Keycloak token endpoint, numbering the tokens it issues, and
+// a Deployment Manager endpoint answering with the status returned by deploy
+func upstreams(t *testing.T, deploy func(token string) int) (*httptest.Server, *atomic.Int32) {
+	var tokens atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/token") && r.PostFormValue("client_secret") != "secret" {
+			tokens.Add(1)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid_client","error_description":"Invalid client credentials"}`))
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/token") {
+			n := tokens.Add(1)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(models.JWT{AccessToken: "token-" + strconv.Itoa(int(n)), ExpiresIn: 900})
+			return
+		}
+		w.WriteHeader(deploy(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")))
+	}))
+	t.Cleanup(server.Close)
+
+	models.Configure(config.Keycloak{BaseURL: server.URL, Realm: "icos-dev", ClientID: "sidecar", ClientSecret: "secret"})
+	models.InvalidateTokenCache()
+	policy := retry.DefaultPolicy()
+	policy.MaxAttempts = 1
+	configureDeployManager(config.DeployManager{URL: server.URL, Retry: policy, Breaker: breaker.DefaultSettings()}, models.KeycloakTokenRequester{})
+	return server, &tokens
+}
+
+func TestScheduleRun(t *testing.T) {
+	task := &Task{Name: TaskExecute, Path: "/execute"}
+
+	t.Run("should retry once with a new token when the token is rejected", func(t *testing.T) {
+		_, tokens := upstreams(t, func(token string) int {
+			if token == "token-1" {
+				return http.StatusUnauthorized
+			}
+			return http.StatusOK
+		})
+		evictions := testutil.ToFloat64(metrics.TokenEvictions.WithLabelValues("401"))
+
+		result := scheduleRun(context.Background(), task, newRunID())
+
+		assert.True(t, result.Succeeded())
+		assert.Equal(t, http.StatusOK, result.StatusCode)
+		assert.Equal(t, models.TokenFresh, result.TokenSource)
+		assert.Equal(t, int32(2), tokens.Load())
+		assert.Equal(t, evictions+1, testutil.ToFloat64(metrics.TokenEvictions.WithLabelValues("401")))
+	})
+
+	t.Run("should fail when the new token is rejected too", func(t *testing.T) {
+		var calls atomic.Int32
+		_, tokens := upstreams(t, func(token string) int {
+			calls.Add(1)
+			return http.StatusForbidden
+		})
+
+		result := scheduleRun(context.Background(), task, newRunID())
+
+		assert.False(t, result.Succeeded())
+		assert.Equal(t, http.StatusForbidden, result.StatusCode)
+		assert.Equal(t, int32(2), calls.Load())
+		assert.Equal(t, int32(2), tokens.Load())
+	})
+	t.Run("should not retry when the credentials are rejected", func(t *testing.T) {
+		server, tokens := upstreams(t, func(token string) int { return http.StatusOK })
+		models.Configure(config.Keycloak{BaseURL: server.URL, Realm: "icos-dev", ClientID: "sidecar", ClientSecret: "wrong"})
+		configureDeployManager(config.DeployManager{URL: server.URL, Retry: retry.DefaultPolicy(), Breaker: breaker.DefaultSettings()}, models.KeycloakTokenRequester{})
+
+		result := scheduleRun(context.Background(), task, newRunID())
+
+		assert.False(t, result.Succeeded())
+		assert.Equal(t, ErrorAuth, result.ErrorClass)
+		assert.Equal(t, 1, result.Attempts)
+		assert.Equal(t, int32(1), tokens.Load())
+	})
+	t.Run("should send no token without authentication", func(t *testing.T) {
+		server, tokens := upstreams(t, func(token string) int {
+			if token != "" {
+				return http.StatusBadRequest
+			}
+			return http.StatusOK
+		})
+		policy := retry.DefaultPolicy()
+		policy.MaxAttempts = 1
+		configureDeployManager(config.DeployManager{URL: server.URL, Retry: policy, Breaker: breaker.DefaultSettings()}, models.NoAuthRequester{})
+
+		result := scheduleRun(context.Background(), task, newRunID())
+
+		assert.True(t, result.Succeeded())
+		assert.Equal(t, models.TokenLocal, result.TokenSource)
+		assert.Equal(t, int32(0), tokens.Load())
+	})
+}
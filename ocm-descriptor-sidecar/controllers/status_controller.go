@@ -0,0 +1,41 @@
+/*
+  OCM-DESCRIPTOR-SIDECAR
+  Copyright © 2022-2024 EVIDEN
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+  This work has received funding from the European Union's HORIZON research
+  and innovation programme under grant agreement No. 101070177.
+*/
+
+package controllers
+
+import (
+	"icos/server/ocm-descriptor-sidecar/responses"
+	"icos/server/ocm-descriptor-sidecar/utils/breaker"
+	"net/http"
+)
+
+type StatusResponse struct {
+	DeployManager  breaker.Status `json:"deploy_manager"`
+	Tasks          []TaskStatus   `json:"tasks"`
+	LeaderElection LeaderStatus   `json:"leader_election"`
+}
+
+func (server *Server) Status(w http.ResponseWriter, r *http.Request) {
+	responses.JSON(w, http.StatusOK, StatusResponse{
+		DeployManager:  deployManager.Load().breaker.Status(),
+		Tasks:          server.TaskStatuses(),
+		LeaderElection: server.LeaderStatus(),
+	})
+}
@@ -0,0 +1,119 @@
+/*
+  OCM-DESCRIPTOR-SIDECAR
+  Copyright © 2022-2024 EVIDEN
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+  This work has received funding from the European Union's HORIZON research
+  and innovation programme under grant agreement No. 101070177.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"icos/server/ocm-descriptor-sidecar/config"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	TaskExecute = "execute"
+	TaskSync    = "sync"
+)
+
+// ConcurrencyPolicy tells what to do when a task is due while its previous run is
+// still in progress, it is parsed and validated by the config package
+type ConcurrencyPolicy = config.ConcurrencyPolicy
+
+const (
+	ForbidConcurrent  = config.ForbidConcurrent
+	AllowConcurrent   = config.AllowConcurrent
+	ReplaceConcurrent = config.ReplaceConcurrent
+)
+
+// Task is a named call to the Deployment Manager triggered on its own schedule.
+// A task runs either every Interval or following a standard 5-field Cron
+// expression; Cron takes precedence when both are set.
+type Task struct {
+	Name              string
+	Path              string
+	Interval          time.Duration
+	Cron              string
+	InitialDelay      time.Duration
+	RunAtStartup      bool
+	ConcurrencyPolicy ConcurrencyPolicy
+
+	schedule cron.Schedule
+}
+
+// defaultTasks returns the tasks run by the sidecar before their schedule is configured
+func defaultTasks() []*Task {
+	return []*Task{
+		// trigger the execution of the jobs
+		{Name: TaskExecute, Path: "/execute"},
+		// update status of all deployed resources into JM periodically
+		{Name: TaskSync, Path: "/resource/sync"},
+	}
+}
+
+// NewTasks builds the task list from the configuration of every task, which is
+// checked by config.Task.Validate
+func NewTasks(tasksConfig config.Tasks) ([]*Task, error) {
+	tasks := defaultTasks()
+	for _, task := range tasks {
+		c, _ := tasksConfig.Get(task.Name)
+		if err := c.Validate(); err != nil {
+			return nil, fmt.Errorf("task %s: %w", task.Name, err)
+		}
+		// neither can fail once validated
+		task.schedule, _ = c.Schedule()
+		task.ConcurrencyPolicy, _ = config.ParseConcurrencyPolicy(c.ConcurrencyPolicy)
+		task.Interval = c.Interval
+		task.Cron = c.Cron
+		task.InitialDelay = c.InitialDelay
+		task.RunAtStartup = c.RunAtStartup
+	}
+	return tasks, nil
+}
+
+// firstRun returns the time of the first run of the task when the scheduler starts at the given time
+func (task *Task) firstRun(start time.Time) time.Time {
+	start = start.Add(task.InitialDelay)
+	if task.RunAtStartup {
+		return start
+	}
+	return task.next(start)
+}
+
+// next returns the first activation of the task strictly after the given time
+func (task *Task) next(after time.Time) time.Time {
+	if task.schedule == nil {
+		return after.Add(task.Interval)
+	}
+	return task.schedule.Next(after)
+}
+
+// period returns the time between two activations of the task following the given time
+func (task *Task) period(after time.Time) time.Duration {
+	next := task.next(after)
+	return task.next(next).Sub(next)
+}
+
+func (task *Task) String() string {
+	if task.Cron != "" {
+		return fmt.Sprintf("%s (cron %q)", task.Name, task.Cron)
+	}
+	return fmt.Sprintf("%s (every %s)", task.Name, task.Interval)
+}
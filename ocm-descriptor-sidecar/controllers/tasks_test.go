@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"icos/server/ocm-descriptor-sidecar/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTasks(t *testing.T) {
+	t.Run("should use the default schedule", func(t *testing.T) {
+		tasks, err := NewTasks(config.Default().Tasks)
+
+		assert.NoError(t, err)
+		assert.Len(t, tasks, 2)
+		for _, task := range tasks {
+			assert.Equal(t, 15*time.Second, task.Interval)
+			assert.Equal(t, ForbidConcurrent, task.ConcurrencyPolicy)
+			assert.False(t, task.RunAtStartup)
+		}
+	})
+
+	t.Run("should apply the task configuration", func(t *testing.T) {
+		c := config.Default().Tasks
+		c.Execute = config.Task{Interval: 3 * time.Second, InitialDelay: time.Second, RunAtStartup: true, ConcurrencyPolicy: "replace"}
+		c.Sync.Cron = "*/1 * * * *"
+
+		tasks, err := NewTasks(c)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 3*time.Second, tasks[0].Interval)
+		assert.Equal(t, time.Second, tasks[0].InitialDelay)
+		assert.True(t, tasks[0].RunAtStartup)
+		assert.Equal(t, ReplaceConcurrent, tasks[0].ConcurrencyPolicy)
+		assert.Equal(t, "*/1 * * * *", tasks[1].Cron)
+	})
+
+	t.Run("should return error on invalid values", func(t *testing.T) {
+		c := config.Default().Tasks
+		c.Sync.Cron = "every minute"
+
+		_, err := NewTasks(c)
+
+		assert.Error(t, err)
+	})
+}
+
+func TestTaskNext(t *testing.T) {
+	start := time.Date(2024, 1, 1, 10, 0, 30, 0, time.UTC)
+
+	t.Run("should run at startup after the initial delay", func(t *testing.T) {
+		task := &Task{Name: "test", Interval: time.Minute, InitialDelay: 5 * time.Second, RunAtStartup: true}
+
+		assert.Equal(t, start.Add(5*time.Second), task.firstRun(start))
+	})
+
+	t.Run("should wait one interval when not running at startup", func(t *testing.T) {
+		task := &Task{Name: "test", Interval: 3 * time.Second}
+
+		assert.Equal(t, start.Add(3*time.Second), task.firstRun(start))
+	})
+
+	t.Run("should follow the cron expression", func(t *testing.T) {
+		schedule, err := config.Task{Cron: "*/5 * * * *"}.Schedule()
+		assert.NoError(t, err)
+		task := &Task{Name: "test", Cron: "*/5 * * * *", schedule: schedule}
+
+		assert.Equal(t, time.Date(2024, 1, 1, 10, 5, 0, 0, time.UTC), task.next(start))
+	})
+}
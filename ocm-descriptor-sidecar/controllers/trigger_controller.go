@@ -0,0 +1,109 @@
+/*
+  OCM-DESCRIPTOR-SIDECAR
+  Copyright © 2022-2024 EVIDEN
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+  This work has received funding from the European Union's HORIZON research
+  and innovation programme under grant agreement No. 101070177.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"icos/server/ocm-descriptor-sidecar/responses"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// RunResponse is returned for runs that are still in progress
+type RunResponse struct {
+	RunID  string `json:"run_id"`
+	Task   string `json:"task"`
+	Status string `json:"status"`
+}
+
+// Trigger starts a run of the task named in the path, e.g. POST /trigger/execute.
+// The result is returned once the run is over, unless the async query parameter is
+// true, in which case the run ID is returned straight away to be polled on /runs/{id}.
+func (server *Server) Trigger(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["task"]
+	async, err := strconv.ParseBool(r.URL.Query().Get("async"))
+	if r.URL.Query().Get("async") != "" && err != nil {
+		responses.ERROR(w, http.StatusBadRequest, errors.New("async: "+err.Error()))
+		return
+	}
+	runner := server.runner(name)
+	if runner == nil {
+		responses.ERROR(w, http.StatusNotFound, errors.New("unknown task "+name))
+		return
+	}
+
+	runID, done, err := runner.trigger(server.runCtx)
+	if errors.Is(err, ErrRunInProgress) || errors.Is(err, ErrPaused) {
+		responses.ERROR(w, http.StatusConflict, err)
+		return
+	}
+	// only the leader calls the deploy manager, and no run starts once it is stopping
+	if errors.Is(err, ErrNotLeader) || errors.Is(err, ErrStopped) {
+		responses.ERROR(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	if err != nil {
+		responses.ERROR(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if async {
+		w.Header().Set("Location", "/runs/"+runID)
+		responses.JSON(w, http.StatusAccepted, RunResponse{RunID: runID, Task: name, Status: "running"})
+		return
+	}
+	// the run goes on if the client goes away, its result ends up in the history
+	select {
+	case result := <-done:
+		writeResult(w, result)
+	case <-r.Context().Done():
+	}
+}
+
+// GetRun returns the result of a run, or its progress if it is not over yet
+func (server *Server) GetRun(w http.ResponseWriter, r *http.Request) {
+	runID := mux.Vars(r)["id"]
+	if result, ok := server.history.Find(runID); ok {
+		writeResult(w, result)
+		return
+	}
+	server.mu.Lock()
+	runners := append([]*taskRunner(nil), server.runners...)
+	server.mu.Unlock()
+	for _, runner := range runners {
+		if runner.running(runID) {
+			responses.JSON(w, http.StatusAccepted, RunResponse{RunID: runID, Task: runner.name(), Status: "running"})
+			return
+		}
+	}
+	responses.ERROR(w, http.StatusNotFound, errors.New("unknown run "+runID))
+}
+
+// writeResult answers with the result of a run, with a 502 status when the run failed
+func writeResult(w http.ResponseWriter, result ScheduleResult) {
+	if result.Succeeded() {
+		responses.JSON(w, http.StatusOK, result)
+		return
+	}
+	responses.JSON(w, http.StatusBadGateway, result)
+}
@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func triggerServer(policy ConcurrencyPolicy) (*Server, chan struct{}) {
+	runner, release := blockingRunner(policy)
+	runner.task.Name = TaskExecute
+	server := &Server{runCtx: context.Background(), history: NewHistory(10)}
+	runner.record = server.history.Add
+	server.runners = []*taskRunner{runner}
+	return server, release
+}
+
+func TestTrigger(t *testing.T) {
+	t.Run("should return the result of the run", func(t *testing.T) {
+		server, release := triggerServer(ForbidConcurrent)
+		close(release)
+
+		w := httptest.NewRecorder()
+		r := mux.SetURLVars(httptest.NewRequest("POST", "/trigger/execute", nil), map[string]string{"task": TaskExecute})
+		server.Trigger(w, r)
+
+		var result ScheduleResult
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&result))
+		assert.Equal(t, OutcomeSuccess, result.Outcome)
+	})
+
+	t.Run("should return a run ID to poll when async", func(t *testing.T) {
+		server, release := triggerServer(ForbidConcurrent)
+
+		w := httptest.NewRecorder()
+		r := mux.SetURLVars(httptest.NewRequest("POST", "/trigger/execute?async=true", nil), map[string]string{"task": TaskExecute})
+		server.Trigger(w, r)
+
+		var run RunResponse
+		assert.Equal(t, http.StatusAccepted, w.Code)
+		assert.NoError(t, json.NewDecoder(w.Body).Decode(&run))
+
+		w = httptest.NewRecorder()
+		server.GetRun(w, mux.SetURLVars(httptest.NewRequest("GET", "/runs/"+run.RunID, nil), map[string]string{"id": run.RunID}))
+		assert.Equal(t, http.StatusAccepted, w.Code)
+
+		close(release)
+		server.runners[0].runs.Wait()
+		w = httptest.NewRecorder()
+		server.GetRun(w, mux.SetURLVars(httptest.NewRequest("GET", "/runs/"+run.RunID, nil), map[string]string{"id": run.RunID}))
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("should return conflict while a forbidden run is in progress", func(t *testing.T) {
+		server, release := triggerServer(ForbidConcurrent)
+		defer close(release)
+		_, _, err := server.runners[0].trigger(context.Background())
+		assert.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		r := mux.SetURLVars(httptest.NewRequest("POST", "/trigger/execute", nil), map[string]string{"task": TaskExecute})
+		server.Trigger(w, r)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("should refuse to run on a leader election follower", func(t *testing.T) {
+		server, _ := triggerServer(ForbidConcurrent)
+		server.runners[0].canRun = func() bool { return false }
+
+		w := httptest.NewRecorder()
+		r := mux.SetURLVars(httptest.NewRequest("POST", "/trigger/execute", nil), map[string]string{"task": TaskExecute})
+		server.Trigger(w, r)
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, uint64(0), server.runners[0].Status().Runs)
+	})
+
+	t.Run("should refuse to run once the scheduler is stopped", func(t *testing.T) {
+		server, _ := triggerServer(ForbidConcurrent)
+		server.runners[0].stop()
+
+		w := httptest.NewRecorder()
+		r := mux.SetURLVars(httptest.NewRequest("POST", "/trigger/execute", nil), map[string]string{"task": TaskExecute})
+		server.Trigger(w, r)
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	t.Run("should return not found for unknown tasks", func(t *testing.T) {
+		server, _ := triggerServer(ForbidConcurrent)
+
+		w := httptest.NewRecorder()
+		r := mux.SetURLVars(httptest.NewRequest("POST", "/trigger/deploy", nil), map[string]string{"task": "deploy"})
+		server.Trigger(w, r)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
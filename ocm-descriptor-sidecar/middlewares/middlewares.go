@@ -27,6 +27,7 @@ import (
 	"errors"
 	"fmt"
 	"icos/server/ocm-descriptor-sidecar/responses"
+	"icos/server/ocm-descriptor-sidecar/utils/logs"
 	"net/http"
 	"strings"
 	"time"
@@ -34,6 +35,8 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+var logger = logs.Component("http")
+
 var (
 	base64EncodedPublicKey = "MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEAgTGF4mKVEa+eWX0S/+EWIfkkqbLba5WuQ1KKGRQz+P56Y0WNRbgjNl0CObndffmixbpgp4kg5jKq78HoFFP7bj0jQSNC3P26K9xPolFXbAlNJe41VMdI7xOkOF0D9GCplEylGlUlCgpaBnbloI4WcbH+RQ6n6Qp6MmNE+/xC3OMMhgEBacbiGtIR71N/HcDYDUORE335sSRpkrHhMxk3eWgZdIyfX88n9UkI3CtgNGIGgF8/w7ZYF2XBmVuv5+QE9d5fM9pZKWQnzBnsMJy4Xc+qZrZMI45KCHIW/DSFVGSsGboiVHSNVOu3mNhPSjvJtIH/7lItCG6m5zvBAvNf8QIDAQAB"
 )
@@ -47,8 +50,9 @@ func SetMiddlewareJSON(next http.HandlerFunc) http.HandlerFunc {
 
 func SetMiddlewareLog(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		logHttpCall(r.Method + " " + r.URL.String())
+		start := time.Now()
 		next(w, r)
+		logger.Info("request served", "method", r.Method, "url", r.URL.String(), "duration", time.Since(start).Round(time.Millisecond))
 	}
 }
 
@@ -97,7 +101,7 @@ func JWTValidation(next http.HandlerFunc) http.HandlerFunc {
 		}
 
 		claims := token.Claims.(jwt.MapClaims)
-		fmt.Println("Claims:", claims)
+		logger.Debug("token validated", "claims", claims)
 		next(w, r)
 	}
 }
@@ -117,7 +121,3 @@ func parseKeycloakRSAPublicKey(base64Encoded string) (*rsa.PublicKey, error) {
 	}
 	return nil, fmt.Errorf("unexpected key type %T", publicKey)
 }
-
-func logHttpCall(format string, args ...interface{}) {
-	fmt.Printf(time.Now().Format(time.RFC3339)+"  \x1b[34;1m%s\x1b[0m\n", fmt.Sprintf(format, args...))
-}
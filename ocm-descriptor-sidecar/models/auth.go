@@ -21,22 +21,28 @@
 package models
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
+	"icos/server/ocm-descriptor-sidecar/config"
 	"icos/server/ocm-descriptor-sidecar/utils/logs"
+	"icos/server/ocm-descriptor-sidecar/utils/oidc"
+	"icos/server/ocm-descriptor-sidecar/utils/tracing"
 	"io"
-	"log"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
-	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
 // This will allow us to easily mock the token request logic in tests
 type TokenRequester interface {
-	RequestNewToken() (JWT, error)
+	RequestNewToken(ctx context.Context) (JWT, error)
+}
+
+// TokenRefresher is implemented by the requesters that can renew a token with its refresh token
+type TokenRefresher interface {
+	RefreshToken(ctx context.Context, refreshToken string) (JWT, error)
 }
 
 // KeycloakTokenRequester is a concrete implementation of the TokenRequester interface
@@ -53,45 +59,200 @@ type JWT struct {
 	Scope            string `json:"scope"`
 }
 
-type CachedToken struct {
-	Token      JWT
-	ExpiryTime time.Time
-}
+// tokenStatusRetryInterval is how long TokenStatus reports a failed token request before
+// requesting a token again
+const tokenStatusRetryInterval = time.Minute
 
+// set by Configure from the configuration
 var (
-	keyCloakURL      = os.Getenv("KEYCLOAK_BASE_URL") // "https://keycloak.dev.icos.91.109.56.214.sslip.io"
-	keyCloakRealm    = os.Getenv("KEYCLOAK_REALM")    // "icos-dev"
-	keyCloakTokenURL = keyCloakURL + "/realms/" + keyCloakRealm + "/protocol/openid-connect/token"
-	clientID         = os.Getenv("KEYCLOAK_CLIENT_ID")
-	clientSecret     = os.Getenv("KEYCLOAK_CLIENT_SECRET")
-	tokenCache       = make(map[string]CachedToken)
+	// mu guards the client settings, as they are replaced on reload
+	mu sync.Mutex
+	// endpoints are the Keycloak endpoints of the realm, unless provider is set
+	endpoints oidc.Endpoints
+	provider  *oidc.Provider
+	client    ClientCredentials
+
+	tokenCache = NewTokenCache()
+
+	logger = logs.Component("auth")
 )
 
+// TokenSource tells whether a token came from the cache, from a new request or, for
+// the requesters that do not call a token endpoint, from the requester itself
+type TokenSource string
+
+const (
+	TokenCached TokenSource = "cached"
+	TokenFresh  TokenSource = "fresh"
+	TokenLocal  TokenSource = "local"
+)
+
+// Configure sets the Keycloak client used to request the tokens
+func Configure(keycloak config.Keycloak) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	endpoints = oidc.Keycloak(keycloak.BaseURL, keycloak.Realm)
+	provider = nil
+	if keycloak.IssuerURL != "" {
+		provider = oidc.NewProvider(keycloak.IssuerURL, keycloak.DiscoveryRefreshInterval)
+	}
+	client = ClientCredentials{ClientID: keycloak.ClientID, ClientSecret: keycloak.ClientSecret, Auth: keycloak.ClientAuth}
+	tokenCache.Configure(keycloak.ExpirySkew, keycloak.RefreshRatio)
+}
+
+// Endpoints returns the endpoints of the issuer of the tokens, read from its discovery
+// document when an issuer URL is configured
+func Endpoints(ctx context.Context) (oidc.Endpoints, error) {
+	mu.Lock()
+	p, e := provider, endpoints
+	mu.Unlock()
+
+	if p == nil {
+		return e, nil
+	}
+	return p.Endpoints(ctx)
+}
+
+// InvalidateTokenCache drops every cached token, so that the next call requests a new one
+func InvalidateTokenCache() {
+	tokenCache.Invalidate()
+}
+
+// EvictToken drops the token of the requester from the cache after an upstream
+// rejected it with the given status code, so that the next call requests a new one
+func EvictToken(ctx context.Context, requester TokenRequester, token JWT, statusCode int) {
+	if !tokenCache.Evict(cacheKey(requester), token.AccessToken) {
+		return
+	}
+	recordTokenEviction(statusCode)
+	logs.FromContext(ctx, logger).Warn("cached token evicted", "status_code", statusCode)
+}
+
+// currentClientID returns the client ID the tokens are cached by
+func currentClientID() string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return client.ClientID
+}
+
 // FetchKeycloakToken fetches a token from the Keycloak server
-func FetchKeycloakToken(requester TokenRequester) (JWT, error) {
-	if cachedToken, err := getCachedToken(clientID); err == nil {
-		logs.Logger.Println("Using Cached Token")
-		return cachedToken, nil
+func FetchKeycloakToken(ctx context.Context, requester TokenRequester) (JWT, error) {
+	token, _, err := FetchToken(ctx, requester)
+	return token, err
+}
+
+// FetchToken fetches a token like FetchKeycloakToken and reports where it came from.
+// Concurrent callers missing the cache share a single token request.
+func FetchToken(ctx context.Context, requester TokenRequester) (JWT, TokenSource, error) {
+	if local, ok := requester.(localRequester); ok {
+		token, err := local.RequestNewToken(ctx)
+		tokenCache.record(cacheKey(requester), err)
+		if err != nil {
+			return JWT{}, "", err
+		}
+		return token, TokenLocal, nil
 	}
 
-	logs.Logger.Println("Requesting New Token")
-	token, err := requester.RequestNewToken()
+	token, source, err := tokenCache.Fetch(ctx, cacheKey(requester), func(ctx context.Context, refreshToken string) (JWT, error) {
+		return requestToken(ctx, requester, refreshToken)
+	})
+	recordTokenCache(source == TokenCached)
 	if err != nil {
-		return JWT{}, err
+		return JWT{}, "", err
 	}
+	if source == TokenCached {
+		logs.FromContext(ctx, logger).Debug("using cached token")
+	}
+	return token, source, nil
+}
 
-	storeToken(token)
-	return token, nil
+// TokenStatus reports whether a token can be obtained for the requester from its cached
+// token or the outcome of its last request, so that the readiness probes do not load the
+// token endpoint. A token is only requested when none was requested yet, or when the
+// last request failed more than tokenStatusRetryInterval ago, since the followers of a
+// leader election make no other request that would tell it recovered.
+func TokenStatus(ctx context.Context, requester TokenRequester) error {
+	if known, err := tokenCache.status(cacheKey(requester), time.Now(), tokenStatusRetryInterval); known {
+		return err
+	}
+	_, _, err := FetchToken(ctx, requester)
+	return err
+}
+
+// cacheKey returns the key the tokens of the requester are cached under
+func cacheKey(requester TokenRequester) string {
+	if keyer, ok := requester.(cacheKeyer); ok {
+		return keyer.cacheKey()
+	}
+	return currentClientID()
+}
+
+// requestToken renews the token with the refresh token when there is one, falling
+// back to a new token when the refresh fails
+func requestToken(ctx context.Context, requester TokenRequester, refreshToken string) (JWT, error) {
+	log := logs.FromContext(ctx, logger)
+	if refresher, ok := requester.(TokenRefresher); ok && refreshToken != "" {
+		log.Info("refreshing token")
+		token, err := refresher.RefreshToken(ctx, refreshToken)
+		recordTokenFetch(err)
+		if err == nil {
+			return token, nil
+		}
+		log.Warn("token refresh failed, requesting a new token", "error", err)
+	}
+
+	log.Info("requesting new token")
+	token, err := requester.RequestNewToken(ctx)
+	recordTokenFetch(err)
+	return token, err
 }
 
 // RequestNewToken requests a new token from the Keycloak server
-func (k KeycloakTokenRequester) RequestNewToken() (JWT, error) {
-	reqToken, err := createTokenRequest()
+func (k KeycloakTokenRequester) RequestNewToken(ctx context.Context) (JWT, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	return k.requestToken(ctx, form)
+}
+
+// RefreshToken renews the token with its refresh token
+func (k KeycloakTokenRequester) RefreshToken(ctx context.Context, refreshToken string) (JWT, error) {
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", refreshToken)
+	return k.requestToken(ctx, form)
+}
+
+// requestToken sends the token request with the given grant to the Keycloak server
+func (k KeycloakTokenRequester) requestToken(ctx context.Context, form url.Values) (JWT, error) {
+	e, err := Endpoints(ctx)
 	if err != nil {
+		logs.FromContext(ctx, logger).Error("cannot resolve the token endpoint", "error", err)
 		return JWT{}, err
 	}
+	mu.Lock()
+	credentials := client
+	mu.Unlock()
 
-	resToken, err := sendTokenRequest(reqToken)
+	return postTokenRequest(ctx, e.TokenEndpoint, credentials, form)
+}
+
+// postTokenRequest sends the form to the token endpoint, authenticated with the client
+// credentials, and returns the token of the response
+func postTokenRequest(ctx context.Context, tokenURL string, credentials ClientCredentials, form url.Values) (JWT, error) {
+	httpClient, err := credentials.authenticate(tokenURL, form)
+	if err != nil {
+		logs.FromContext(ctx, logger).Error("cannot authenticate the client", "method", credentials.Auth.Method, "error", err)
+		return JWT{}, err
+	}
+
+	reqToken, err := createTokenRequest(ctx, tokenURL, form)
+	if err != nil {
+		return JWT{}, err
+	}
+
+	resToken, err := sendTokenRequest(httpClient, reqToken)
 	if err != nil {
 		return JWT{}, err
 	}
@@ -106,18 +267,10 @@ func (k KeycloakTokenRequester) RequestNewToken() (JWT, error) {
 }
 
 // createTokenRequest creates the token request
-func createTokenRequest() (*http.Request, error) {
-	reqTokenBody := url.Values{}
-	reqTokenBody.Set("client_id", clientID)
-	reqTokenBody.Set("grant_type", "client_credentials")
-	reqTokenBody.Set("client_secret", clientSecret)
-
-	logs.Logger.Println("Request Token Body is: ")
-	logs.Logger.Println(reqTokenBody)
-
-	reqToken, err := http.NewRequest("POST", keyCloakTokenURL, strings.NewReader(reqTokenBody.Encode()))
+func createTokenRequest(ctx context.Context, tokenURL string, form url.Values) (*http.Request, error) {
+	reqToken, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(form.Encode()))
 	if err != nil {
-		logs.Logger.Println("ERROR " + err.Error())
+		logs.FromContext(ctx, logger).Error("cannot build the token request", "error", err)
 		return nil, err
 	}
 
@@ -125,60 +278,52 @@ func createTokenRequest() (*http.Request, error) {
 	return reqToken, nil
 }
 
-// sendTokenRequest sends the token request to the server
-func sendTokenRequest(reqToken *http.Request) (*http.Response, error) {
-	client := &http.Client{}
+// sendTokenRequest sends the token request to the server with the client
+func sendTokenRequest(client *http.Client, reqToken *http.Request) (*http.Response, error) {
+	log := logs.FromContext(reqToken.Context(), logger).With("upstream", "token-endpoint")
+	logs.DebugRequest(log, "token request", reqToken)
+
+	reqToken, span := tracing.StartClientSpan(reqToken, "POST token")
 	resToken, err := client.Do(reqToken)
+	tracing.EndClientSpan(span, resToken, err)
 	if err != nil {
-		logs.Logger.Println("ERROR " + err.Error())
+		log.Error("token request failed", "error", err)
 		return nil, err
 	}
 
-	logs.Logger.Println("New Token Response Info: ")
-	b, err := httputil.DumpResponse(resToken, true)
-	if err != nil {
-		log.Fatalln(err)
-	}
-	fmt.Println(string(b))
+	logs.DebugResponse(reqToken.Context(), log, "token response", resToken)
 
 	return resToken, nil
 }
 
-// parseTokenResponse parses the token response from the server
+// parseTokenResponse parses the token response from the server, turning the error
+// responses into an *OAuth2Error
 func parseTokenResponse(resToken *http.Response) (JWT, error) {
-	var token JWT
-
 	tokenBody, err := io.ReadAll(resToken.Body)
 	if err != nil {
-		logs.Logger.Println("ERROR " + err.Error())
-		return JWT{}, err
-	}
-
-	err = json.Unmarshal(tokenBody, &token)
-	if err != nil {
-		logs.Logger.Println("ERROR " + err.Error())
+		logger.Error("cannot read the token response", "error", err)
 		return JWT{}, err
 	}
 
-	return token, nil
-}
-
-// getCachedToken fetches the token from the cache
-func getCachedToken(clientID string) (JWT, error) {
-	if cachedToken, ok := tokenCache[clientID]; ok {
-		if time.Now().Before(cachedToken.ExpiryTime) {
-			return cachedToken.Token, nil
+	if resToken.StatusCode < 200 || resToken.StatusCode >= 300 {
+		oauthErr := &OAuth2Error{StatusCode: resToken.StatusCode, Status: resToken.Status}
+		// the body is kept out of the error when it is not an OAuth2 error
+		if err := json.Unmarshal(tokenBody, oauthErr); err != nil {
+			oauthErr.Code, oauthErr.Description = "", ""
 		}
-		delete(tokenCache, clientID)
+		logger.Error("token request rejected", "status_code", oauthErr.StatusCode, "error", oauthErr.Code, "error_description", oauthErr.Description)
+		return JWT{}, oauthErr
 	}
-	return JWT{}, fmt.Errorf("token not found in cache")
-}
 
-// StoreToken stores the token in the cache
-func storeToken(token JWT) {
-	cachedToken := CachedToken{
-		Token:      token,
-		ExpiryTime: time.Now().Add(time.Duration(token.ExpiresIn) * time.Second),
+	var token JWT
+	if err := json.Unmarshal(tokenBody, &token); err != nil {
+		logger.Error("cannot parse the token response", "error", err)
+		return JWT{}, err
+	}
+	if token.AccessToken == "" {
+		logger.Error("no access token in the token response")
+		return JWT{}, ErrNoAccessToken
 	}
-	tokenCache[clientID] = cachedToken
+
+	return token, nil
 }
@@ -1,11 +1,24 @@
 package models
 
 import (
+	"context"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"icos/server/ocm-descriptor-sidecar/config"
+	"icos/server/ocm-descriptor-sidecar/utils/metrics"
+	"icos/server/ocm-descriptor-sidecar/utils/oidc"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -14,14 +27,14 @@ func TestGetToken(t *testing.T) {
 		server := mockServer(t)
 		defer server.Close()
 
-		// Override the keyCloakTokenURL with the mock server URL
-		originalKeyCloakTokenURL := keyCloakTokenURL
-		keyCloakTokenURL = server.URL + "/realms/icos-dev/protocol/openid-connect/token"
-		defer func() { keyCloakTokenURL = originalKeyCloakTokenURL }()
+		// Override the token endpoint with the mock server URL
+		originalTokenEndpoint := endpoints.TokenEndpoint
+		endpoints.TokenEndpoint = server.URL + "/realms/icos-dev/protocol/openid-connect/token"
+		defer func() { endpoints.TokenEndpoint = originalTokenEndpoint }()
 
 		requester := KeycloakTokenRequester{}
-		firstToken, _ := FetchKeycloakToken(requester)
-		secondToken, _ := FetchKeycloakToken(requester)
+		firstToken, _ := FetchKeycloakToken(context.Background(), requester)
+		secondToken, _ := FetchKeycloakToken(context.Background(), requester)
 
 		assert.Equal(t, firstToken, secondToken)
 	})
@@ -30,19 +43,44 @@ func TestGetToken(t *testing.T) {
 		server := mockServer(t)
 		defer server.Close()
 
-		// Override the keyCloakTokenURL with the mock server URL
-		originalKeyCloakTokenURL := keyCloakTokenURL
-		keyCloakTokenURL = server.URL + "/realms/icos-dev/protocol/openid-connect/token"
-		defer func() { keyCloakTokenURL = originalKeyCloakTokenURL }()
+		// Override the token endpoint with the mock server URL
+		originalTokenEndpoint := endpoints.TokenEndpoint
+		endpoints.TokenEndpoint = server.URL + "/realms/icos-dev/protocol/openid-connect/token"
+		defer func() { endpoints.TokenEndpoint = originalTokenEndpoint }()
 
 		requester := KeycloakTokenRequester{}
-		firstToken, err := FetchKeycloakToken(requester)
+		firstToken, err := FetchKeycloakToken(context.Background(), requester)
 
 		assert.NoError(t, err)
 		assert.NotEmpty(t, firstToken)
 
 	})
 
+	t.Run("should count cache hits and misses", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(JWT{AccessToken: "mocked_access_token", ExpiresIn: 900})
+		}))
+		defer server.Close()
+
+		originalTokenEndpoint := endpoints.TokenEndpoint
+		endpoints.TokenEndpoint = server.URL + "/realms/icos-dev/protocol/openid-connect/token"
+		defer func() { endpoints.TokenEndpoint = originalTokenEndpoint }()
+		tokenCache.Invalidate()
+		hits := testutil.ToFloat64(metrics.TokenCacheRequests.WithLabelValues("hit"))
+		misses := testutil.ToFloat64(metrics.TokenCacheRequests.WithLabelValues("miss"))
+
+		requester := KeycloakTokenRequester{}
+		_, source, err := FetchToken(context.Background(), requester)
+		assert.NoError(t, err)
+		assert.Equal(t, TokenFresh, source)
+		_, source, _ = FetchToken(context.Background(), requester)
+		assert.Equal(t, TokenCached, source)
+
+		assert.Equal(t, hits+1, testutil.ToFloat64(metrics.TokenCacheRequests.WithLabelValues("hit")))
+		assert.Equal(t, misses+1, testutil.ToFloat64(metrics.TokenCacheRequests.WithLabelValues("miss")))
+	})
+
 	t.Run("should return error when requestNewToken fails", func(t *testing.T) {
 		err := assert.AnError
 		assert.Error(t, err)
@@ -63,14 +101,14 @@ func mockServer(t *testing.T) *httptest.Server {
 func TestStoreToken(t *testing.T) {
 
 	t.Run("should store token", func(t *testing.T) {
-		tokenCache = make(map[string]CachedToken)
+		tokenCache.Invalidate()
 		token := JWT{
 			AccessToken: "mocked_access_token",
 			ExpiresIn:   900,
 		}
-		storeToken(token)
+		tokenCache.Store(currentClientID(), token)
 
-		cachedToken, exists := tokenCache[clientID]
+		cachedToken, exists := tokenCache.tokens[currentClientID()]
 		assert.True(t, exists)
 		assert.Equal(t, token.AccessToken, cachedToken.Token.AccessToken)
 	})
@@ -80,23 +118,171 @@ func TestStoreToken(t *testing.T) {
 func TestGetCachedToken(t *testing.T) {
 
 	t.Run("should return cached token", func(t *testing.T) {
-		tokenCache = make(map[string]CachedToken)
+		tokenCache.Invalidate()
 		token := JWT{
 			AccessToken: "mocked_access_token",
 			ExpiresIn:   900,
 		}
-		storeToken(token)
-		cachedToken, err := getCachedToken(clientID)
+		tokenCache.Store(currentClientID(), token)
+		cachedToken, ok := tokenCache.Get(currentClientID())
 
-		assert.NoError(t, err)
+		assert.True(t, ok)
 		assert.Equal(t, token.AccessToken, cachedToken.AccessToken)
 	})
 
 	t.Run("should return error if token is not found", func(t *testing.T) {
-		tokenCache = make(map[string]CachedToken)
-		_, err := getCachedToken(clientID)
+		tokenCache.Invalidate()
+		_, ok := tokenCache.Get(currentClientID())
 
-		assert.Error(t, err)
+		assert.False(t, ok)
+	})
+
+}
+
+func TestInvalidateTokenCache(t *testing.T) {
+	t.Run("should drop the cached tokens", func(t *testing.T) {
+		tokenCache.Invalidate()
+		tokenCache.Store(currentClientID(), JWT{AccessToken: "mocked_access_token", ExpiresIn: 900})
+
+		InvalidateTokenCache()
+		_, ok := tokenCache.Get(currentClientID())
+
+		assert.False(t, ok)
+	})
+}
+
+func TestParseTokenResponse(t *testing.T) {
+	response := func(status int, body string) *http.Response {
+		return &http.Response{
+			StatusCode: status,
+			Status:     strconv.Itoa(status) + " " + http.StatusText(status),
+			Body:       io.NopCloser(strings.NewReader(body)),
+		}
+	}
+
+	t.Run("should return the OAuth2 error of a rejected request", func(t *testing.T) {
+		_, err := parseTokenResponse(response(401, `{"error":"invalid_client","error_description":"Invalid client credentials"}`))
+
+		var oauthErr *OAuth2Error
+		assert.ErrorAs(t, err, &oauthErr)
+		assert.ErrorIs(t, err, ErrInvalidClient)
+		assert.Equal(t, 401, oauthErr.StatusCode)
+		assert.Equal(t, "Invalid client credentials", oauthErr.Description)
+		assert.False(t, oauthErr.Temporary())
+	})
+
+	t.Run("should return the status when the body is not an OAuth2 error", func(t *testing.T) {
+		_, err := parseTokenResponse(response(502, "<html>Bad Gateway</html>"))
+
+		var oauthErr *OAuth2Error
+		assert.ErrorAs(t, err, &oauthErr)
+		assert.Empty(t, oauthErr.Code)
+		assert.True(t, oauthErr.Temporary())
+		assert.EqualError(t, err, "token endpoint returned 502 Bad Gateway")
+	})
+
+	t.Run("should return an error when the access token is missing", func(t *testing.T) {
+		_, err := parseTokenResponse(response(200, `{"token_type":"Bearer","expires_in":300}`))
+
+		assert.ErrorIs(t, err, ErrNoAccessToken)
 	})
+}
+
+func TestFetchTokenFailures(t *testing.T) {
+	t.Run("should not cache a rejected token request", func(t *testing.T) {
+		var requests atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid_client"}`))
+		}))
+		defer server.Close()
+
+		originalTokenEndpoint := endpoints.TokenEndpoint
+		endpoints.TokenEndpoint = server.URL
+		defer func() { endpoints.TokenEndpoint = originalTokenEndpoint }()
+		tokenCache.Invalidate()
+
+		_, err := FetchKeycloakToken(context.Background(), KeycloakTokenRequester{})
+		assert.ErrorIs(t, err, ErrInvalidClient)
+		_, err = FetchKeycloakToken(context.Background(), KeycloakTokenRequester{})
+		assert.ErrorIs(t, err, ErrInvalidClient)
+
+		assert.Equal(t, int32(2), requests.Load())
+		_, ok := tokenCache.Get(currentClientID())
+		assert.False(t, ok)
+	})
+}
 
+func TestDiscoveredTokenEndpoint(t *testing.T) {
+	t.Run("should request the token from the endpoint of the discovery document", func(t *testing.T) {
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			switch r.URL.Path {
+			case "/.well-known/openid-configuration":
+				json.NewEncoder(w).Encode(oidc.Endpoints{Issuer: server.URL, TokenEndpoint: server.URL + "/oauth2/token"})
+			case "/oauth2/token":
+				json.NewEncoder(w).Encode(JWT{AccessToken: "discovered_access_token", ExpiresIn: 900})
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+		defer server.Close()
+
+		Configure(config.Keycloak{IssuerURL: server.URL, DiscoveryRefreshInterval: time.Hour, ClientID: "sidecar", ClientSecret: "secret"})
+		defer Configure(config.Keycloak{})
+		tokenCache.Invalidate()
+
+		token, err := FetchKeycloakToken(context.Background(), KeycloakTokenRequester{})
+
+		assert.NoError(t, err)
+		assert.Equal(t, "discovered_access_token", token.AccessToken)
+	})
+}
+
+// failingRequester counts its token requests, which all fail
+type failingRequester struct {
+	requests atomic.Int32
+}
+
+func (r *failingRequester) RequestNewToken(ctx context.Context) (JWT, error) {
+	r.requests.Add(1)
+	return JWT{}, ErrInvalidClient
+}
+
+func (r *failingRequester) cacheKey() string {
+	return "failing"
+}
+
+func TestTokenStatus(t *testing.T) {
+	t.Run("should report the last failure without requesting a token again", func(t *testing.T) {
+		tokenCache.Invalidate()
+		requester := &failingRequester{}
+
+		assert.ErrorIs(t, TokenStatus(context.Background(), requester), ErrInvalidClient)
+		assert.ErrorIs(t, TokenStatus(context.Background(), requester), ErrInvalidClient)
+		assert.Equal(t, int32(1), requester.requests.Load())
+
+		tokenCache.mu.Lock()
+		result := tokenCache.results["failing"]
+		result.at = result.at.Add(-tokenStatusRetryInterval)
+		tokenCache.results["failing"] = result
+		tokenCache.mu.Unlock()
+		assert.ErrorIs(t, TokenStatus(context.Background(), requester), ErrInvalidClient)
+		assert.Equal(t, int32(2), requester.requests.Load())
+	})
+
+	t.Run("should not read the token file on every probe", func(t *testing.T) {
+		tokenCache.Invalidate()
+		path := filepath.Join(t.TempDir(), "token")
+		assert.NoError(t, os.WriteFile(path, []byte("file_access_token"), 0o600))
+		requester := FileTokenRequester{Path: path}
+
+		assert.NoError(t, TokenStatus(context.Background(), requester))
+		assert.NoError(t, os.Remove(path))
+
+		assert.NoError(t, TokenStatus(context.Background(), requester))
+	})
 }
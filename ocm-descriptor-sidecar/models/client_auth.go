@@ -0,0 +1,180 @@
+/*
+  OCM-DESCRIPTOR-SIDECAR
+  Copyright © 2022-2024 EVIDEN
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+  This work has received funding from the European Union's HORIZON research
+  and innovation programme under grant agreement No. 101070177.
+*/
+
+package models
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"icos/server/ocm-descriptor-sidecar/config"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// clientAssertionType is the client_assertion_type of the JWT client assertions (RFC 7523 section 2.2)
+const clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// clientAssertionLifetime is how long a client assertion is accepted by the token endpoint
+const clientAssertionLifetime = time.Minute
+
+// ClientCredentials authenticates a client to a token endpoint with the method of Auth
+type ClientCredentials struct {
+	ClientID     string
+	ClientSecret string
+	Auth         config.ClientAuth
+}
+
+// authenticate adds the client authentication to the form of a token request sent to
+// tokenURL and returns the HTTP client to send it with. The keys and certificates are
+// read on every call, so that rotated files are picked up.
+func (c ClientCredentials) authenticate(tokenURL string, form url.Values) (*http.Client, error) {
+	form.Set("client_id", c.ClientID)
+	switch c.Auth.Method {
+	case config.PrivateKeyJWT:
+		assertion, err := c.assertion(tokenURL)
+		if err != nil {
+			return nil, err
+		}
+		form.Set("client_assertion_type", clientAssertionType)
+		form.Set("client_assertion", assertion)
+	case config.TLSClientAuth:
+		// the client is identified by its certificate, the form only carries its ID
+		return c.tlsClient()
+	default:
+		form.Set("client_secret", c.ClientSecret)
+	}
+	return &http.Client{}, nil
+}
+
+// assertion returns a JWT signed with the private key of the client, for the token
+// endpoint as audience (RFC 7523 section 3)
+func (c ClientCredentials) assertion(tokenURL string) (string, error) {
+	key, err := readPrivateKey(c.Auth.PrivateKeyFile)
+	if err != nil {
+		return "", err
+	}
+	method, err := signingMethod(key)
+	if err != nil {
+		return "", fmt.Errorf("the client assertion key %s: %w", c.Auth.PrivateKeyFile, err)
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(method, jwt.RegisteredClaims{
+		Issuer:    c.ClientID,
+		Subject:   c.ClientID,
+		Audience:  jwt.ClaimStrings{tokenURL},
+		ID:        rand.Text(),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(clientAssertionLifetime)),
+	})
+	if c.Auth.KeyID != "" {
+		token.Header["kid"] = c.Auth.KeyID
+	}
+	return token.SignedString(key)
+}
+
+// tlsClient returns an HTTP client presenting the certificate of the client (RFC 8705 section 2)
+func (c ClientCredentials) tlsClient() (*http.Client, error) {
+	certificate, err := tls.LoadX509KeyPair(c.Auth.CertFile, c.Auth.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading the client certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{certificate}}
+	if c.Auth.CAFile != "" {
+		cas, err := os.ReadFile(c.Auth.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading the CA certificates: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(cas) {
+			return nil, errors.New("no certificate in the CA file " + c.Auth.CAFile)
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	// the transport is built for a single request, its connections are not reused
+	transport.DisableKeepAlives = true
+	return &http.Client{Transport: transport}, nil
+}
+
+// readPrivateKey reads a PEM private key in the PKCS #8, PKCS #1 or SEC 1 format
+func readPrivateKey(path string) (crypto.Signer, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading the client assertion key: %w", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM block in the client assertion key " + path)
+	}
+
+	var key any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing the client assertion key %s: %w", path, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("the client assertion key %s cannot sign", path)
+	}
+	return signer, nil
+}
+
+// signingMethod returns the JWS algorithm of the key
+func signingMethod(key crypto.Signer) (jwt.SigningMethod, error) {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return jwt.SigningMethodRS256, nil
+	case *ecdsa.PrivateKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return jwt.SigningMethodES256, nil
+		case elliptic.P384():
+			return jwt.SigningMethodES384, nil
+		case elliptic.P521():
+			return jwt.SigningMethodES512, nil
+		}
+		return nil, fmt.Errorf("unsupported curve %s", key.Curve.Params().Name)
+	case ed25519.PrivateKey:
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", key)
+}
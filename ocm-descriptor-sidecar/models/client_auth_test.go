@@ -0,0 +1,169 @@
+package models
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"icos/server/ocm-descriptor-sidecar/config"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// writePEM writes the PEM block to a file of the test directory and returns its path
+func writePEM(t *testing.T, dir, name, blockType string, bytes []byte) string {
+	path := filepath.Join(dir, name)
+	assert.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: bytes}), 0o600))
+	return path
+}
+
+func TestClientAssertion(t *testing.T) {
+	t.Run("should authenticate with a signed client assertion", func(t *testing.T) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		assert.NoError(t, err)
+		keyFile := writePEM(t, t.TempDir(), "client.key", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))
+
+		var tokenURL string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.NoError(t, r.ParseForm())
+			assert.Empty(t, r.PostForm.Get("client_secret"))
+			assert.Equal(t, clientAssertionType, r.PostForm.Get("client_assertion_type"))
+
+			claims := jwt.RegisteredClaims{}
+			assertion, err := jwt.ParseWithClaims(r.PostForm.Get("client_assertion"), &claims, func(*jwt.Token) (any, error) {
+				return &key.PublicKey, nil
+			}, jwt.WithValidMethods([]string{"RS256"}), jwt.WithAudience(tokenURL), jwt.WithIssuer("deployer"))
+			assert.NoError(t, err)
+			assert.Equal(t, "key-1", assertion.Header["kid"])
+			assert.Equal(t, "deployer", claims.Subject)
+			assert.NotEmpty(t, claims.ID)
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(JWT{AccessToken: "asserted_access_token", ExpiresIn: 900})
+		}))
+		defer server.Close()
+		tokenURL = server.URL + "/token"
+
+		token, err := OAuth2TokenRequester{
+			TokenURL:   tokenURL,
+			ClientID:   "deployer",
+			ClientAuth: config.ClientAuth{Method: config.PrivateKeyJWT, PrivateKeyFile: keyFile, KeyID: "key-1"},
+		}.RequestNewToken(context.Background())
+
+		assert.NoError(t, err)
+		assert.Equal(t, "asserted_access_token", token.AccessToken)
+	})
+
+	t.Run("should sign with the algorithm of the key", func(t *testing.T) {
+		key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		assert.NoError(t, err)
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		assert.NoError(t, err)
+		keyFile := writePEM(t, t.TempDir(), "client.key", "PRIVATE KEY", der)
+
+		assertion, err := ClientCredentials{
+			ClientID: "deployer",
+			Auth:     config.ClientAuth{Method: config.PrivateKeyJWT, PrivateKeyFile: keyFile},
+		}.assertion("https://sso.example.com/token")
+		assert.NoError(t, err)
+
+		token, err := jwt.Parse(assertion, func(*jwt.Token) (any, error) { return &key.PublicKey, nil })
+		assert.NoError(t, err)
+		assert.Equal(t, "ES384", token.Method.Alg())
+		assert.NotContains(t, token.Header, "kid")
+	})
+
+	t.Run("should fail when the key cannot be read", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "client.key")
+		assert.NoError(t, os.WriteFile(path, []byte("not a key"), 0o600))
+
+		_, err := OAuth2TokenRequester{
+			TokenURL:   "http://127.0.0.1:0/token",
+			ClientID:   "deployer",
+			ClientAuth: config.ClientAuth{Method: config.PrivateKeyJWT, PrivateKeyFile: path},
+		}.RequestNewToken(context.Background())
+
+		assert.ErrorContains(t, err, "no PEM block")
+	})
+}
+
+func TestTLSClientAuth(t *testing.T) {
+	t.Run("should authenticate with the client certificate", func(t *testing.T) {
+		dir := t.TempDir()
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.NoError(t, err)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: "deployer"},
+			NotBefore:    time.Now().Add(-time.Minute),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			IsCA:         true,
+
+			BasicConstraintsValid: true,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		assert.NoError(t, err)
+		certificate, err := x509.ParseCertificate(der)
+		assert.NoError(t, err)
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		assert.NoError(t, err)
+		certFile := writePEM(t, dir, "client.crt", "CERTIFICATE", der)
+		keyFile := writePEM(t, dir, "client.key", "EC PRIVATE KEY", keyDER)
+
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.NoError(t, r.ParseForm())
+			assert.Equal(t, "deployer", r.PostForm.Get("client_id"))
+			assert.Empty(t, r.PostForm.Get("client_secret"))
+			assert.Equal(t, "deployer", r.TLS.PeerCertificates[0].Subject.CommonName)
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(JWT{AccessToken: "mtls_access_token", ExpiresIn: 900})
+		}))
+		clientCAs := x509.NewCertPool()
+		clientCAs.AddCert(certificate)
+		server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+		server.StartTLS()
+		defer server.Close()
+		caFile := writePEM(t, dir, "ca.crt", "CERTIFICATE", server.Certificate().Raw)
+
+		token, err := OAuth2TokenRequester{
+			TokenURL: server.URL + "/token",
+			ClientID: "deployer",
+			ClientAuth: config.ClientAuth{
+				Method:   config.TLSClientAuth,
+				CertFile: certFile,
+				KeyFile:  keyFile,
+				CAFile:   caFile,
+			},
+		}.RequestNewToken(context.Background())
+
+		assert.NoError(t, err)
+		assert.Equal(t, "mtls_access_token", token.AccessToken)
+	})
+
+	t.Run("should fail when the certificate cannot be read", func(t *testing.T) {
+		_, err := ClientCredentials{
+			ClientID: "deployer",
+			Auth:     config.ClientAuth{Method: config.TLSClientAuth, CertFile: "/nonexistent/client.crt", KeyFile: "/nonexistent/client.key"},
+		}.tlsClient()
+
+		assert.ErrorContains(t, err, "client certificate")
+	})
+}
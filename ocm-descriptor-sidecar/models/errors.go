@@ -0,0 +1,71 @@
+/*
+  OCM-DESCRIPTOR-SIDECAR
+  Copyright © 2022-2024 EVIDEN
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+  This work has received funding from the European Union's HORIZON research
+  and innovation programme under grant agreement No. 101070177.
+*/
+
+package models
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ErrNoAccessToken is returned when a successful token response has no access token
+var ErrNoAccessToken = errors.New("no access token in the token response")
+
+// The OAuth2 error codes of RFC 6749 section 5.2, to be matched with errors.Is
+var (
+	ErrInvalidRequest       = &OAuth2Error{Code: "invalid_request"}
+	ErrInvalidClient        = &OAuth2Error{Code: "invalid_client"}
+	ErrInvalidGrant         = &OAuth2Error{Code: "invalid_grant"}
+	ErrUnauthorizedClient   = &OAuth2Error{Code: "unauthorized_client"}
+	ErrUnsupportedGrantType = &OAuth2Error{Code: "unsupported_grant_type"}
+	ErrInvalidScope         = &OAuth2Error{Code: "invalid_scope"}
+)
+
+// OAuth2Error is returned when the token endpoint answers with a non 2xx status.
+// Code and Description are empty when the body is not an OAuth2 error, e.g. the
+// error page of a proxy.
+type OAuth2Error struct {
+	StatusCode  int    `json:"-"`
+	Status      string `json:"-"`
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *OAuth2Error) Error() string {
+	switch {
+	case e.Code == "":
+		return "token endpoint returned " + e.Status
+	case e.Description == "":
+		return "token endpoint returned " + e.Code
+	}
+	return "token endpoint returned " + e.Code + ": " + e.Description
+}
+
+// Is matches the errors with the same OAuth2 error code, e.g. ErrInvalidClient
+func (e *OAuth2Error) Is(target error) bool {
+	t, ok := target.(*OAuth2Error)
+	return ok && t.Code != "" && t.Code == e.Code
+}
+
+// Temporary reports whether the request may succeed later without a configuration
+// change, which is only the case of rate limiting and server errors
+func (e *OAuth2Error) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
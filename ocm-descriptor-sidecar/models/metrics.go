@@ -0,0 +1,63 @@
+/*
+  OCM-DESCRIPTOR-SIDECAR
+  Copyright © 2022-2024 EVIDEN
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+  This work has received funding from the European Union's HORIZON research
+  and innovation programme under grant agreement No. 101070177.
+*/
+
+package models
+
+import (
+	"icos/server/ocm-descriptor-sidecar/utils/metrics"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// tokenExpiry reports the seconds until the cached token expires, computed at scrape time
+var tokenExpiry = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+	Namespace: "ocm_sidecar",
+	Name:      "token_expiry_seconds",
+	Help:      "Seconds until the cached token expires, 0 when no token is cached.",
+}, func() float64 {
+	expiry := tokenCache.expiry()
+	if expiry.IsZero() {
+		return 0
+	}
+	return max(time.Until(expiry).Seconds(), 0)
+})
+
+func recordTokenCache(hit bool) {
+	if hit {
+		metrics.TokenCacheRequests.WithLabelValues("hit").Inc()
+		return
+	}
+	metrics.TokenCacheRequests.WithLabelValues("miss").Inc()
+}
+
+func recordTokenFetch(err error) {
+	if err != nil {
+		metrics.TokenFetches.WithLabelValues("failure").Inc()
+		return
+	}
+	metrics.TokenFetches.WithLabelValues("success").Inc()
+}
+
+func recordTokenEviction(statusCode int) {
+	metrics.TokenEvictions.WithLabelValues(strconv.Itoa(statusCode)).Inc()
+}
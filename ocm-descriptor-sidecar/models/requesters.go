@@ -0,0 +1,145 @@
+/*
+  OCM-DESCRIPTOR-SIDECAR
+  Copyright © 2022-2024 EVIDEN
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+  This work has received funding from the European Union's HORIZON research
+  and innovation programme under grant agreement No. 101070177.
+*/
+
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"icos/server/ocm-descriptor-sidecar/config"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// localRequester is implemented by the requesters that do not call a token endpoint.
+// Their tokens are not cached, so that a rotated token is picked up on the next call.
+type localRequester interface {
+	TokenRequester
+	local()
+}
+
+// cacheKeyer is implemented by the requesters whose tokens, or the outcome of their
+// requests for the local ones, are not kept under the Keycloak client ID
+type cacheKeyer interface {
+	cacheKey() string
+}
+
+// NewTokenRequester returns the requester of the authentication mode of the configuration
+func NewTokenRequester(auth config.Auth) TokenRequester {
+	switch strings.ToLower(auth.Mode) {
+	case config.AuthOAuth2:
+		return OAuth2TokenRequester{
+			TokenURL:     auth.OAuth2.TokenURL,
+			ClientID:     auth.OAuth2.ClientID,
+			ClientSecret: auth.OAuth2.ClientSecret,
+			ClientAuth:   auth.OAuth2.ClientAuth,
+			Scopes:       auth.OAuth2.Scopes,
+		}
+	case config.AuthStatic:
+		return StaticTokenRequester{Token: auth.Token}
+	case config.AuthFile:
+		return FileTokenRequester{Path: auth.TokenFile}
+	case config.AuthNone:
+		logger.Warn("the calls to the deployment manager are not authenticated")
+		return NoAuthRequester{}
+	}
+	return KeycloakTokenRequester{}
+}
+
+// OAuth2TokenRequester gets the tokens from an OAuth2 token endpoint with the client
+// credentials grant (RFC 6749 section 4.4)
+type OAuth2TokenRequester struct {
+	TokenURL     string
+	ClientID     string
+	ClientSecret string
+	// ClientAuth is the way the client authenticates, with the client secret by default
+	ClientAuth config.ClientAuth
+	Scopes     []string
+}
+
+// RequestNewToken requests a new token from the token endpoint
+func (o OAuth2TokenRequester) RequestNewToken(ctx context.Context) (JWT, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if len(o.Scopes) > 0 {
+		form.Set("scope", strings.Join(o.Scopes, " "))
+	}
+	credentials := ClientCredentials{ClientID: o.ClientID, ClientSecret: o.ClientSecret, Auth: o.ClientAuth}
+	return postTokenRequest(ctx, o.TokenURL, credentials, form)
+}
+
+func (o OAuth2TokenRequester) cacheKey() string {
+	return o.TokenURL + " " + o.ClientID
+}
+
+// StaticTokenRequester sends a fixed bearer token
+type StaticTokenRequester struct {
+	Token string
+}
+
+func (s StaticTokenRequester) RequestNewToken(ctx context.Context) (JWT, error) {
+	return JWT{AccessToken: s.Token, TokenType: "Bearer"}, nil
+}
+
+func (StaticTokenRequester) local() {}
+
+func (StaticTokenRequester) cacheKey() string {
+	return "static"
+}
+
+// FileTokenRequester reads the token from a file on every call, such as a projected
+// Kubernetes service account token that the kubelet rotates
+type FileTokenRequester struct {
+	Path string
+}
+
+func (f FileTokenRequester) RequestNewToken(ctx context.Context) (JWT, error) {
+	b, err := os.ReadFile(f.Path)
+	if err != nil {
+		return JWT{}, fmt.Errorf("reading the token file: %w", err)
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return JWT{}, errors.New("the token file " + f.Path + " is empty")
+	}
+	return JWT{AccessToken: token, TokenType: "Bearer"}, nil
+}
+
+func (FileTokenRequester) local() {}
+
+func (f FileTokenRequester) cacheKey() string {
+	return "file " + f.Path
+}
+
+// NoAuthRequester sends no token, it is meant for local development against a stubbed
+// Deployment Manager
+type NoAuthRequester struct{}
+
+func (NoAuthRequester) RequestNewToken(ctx context.Context) (JWT, error) {
+	return JWT{}, nil
+}
+
+func (NoAuthRequester) local() {}
+
+func (NoAuthRequester) cacheKey() string {
+	return "none"
+}
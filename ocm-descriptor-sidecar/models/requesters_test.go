@@ -0,0 +1,95 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"icos/server/ocm-descriptor-sidecar/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTokenRequester(t *testing.T) {
+	t.Run("should select the requester of the mode", func(t *testing.T) {
+		assert.IsType(t, KeycloakTokenRequester{}, NewTokenRequester(config.Auth{Mode: "keycloak"}))
+		assert.IsType(t, OAuth2TokenRequester{}, NewTokenRequester(config.Auth{Mode: "oauth2"}))
+		assert.IsType(t, StaticTokenRequester{}, NewTokenRequester(config.Auth{Mode: "static"}))
+		assert.IsType(t, FileTokenRequester{}, NewTokenRequester(config.Auth{Mode: "File"}))
+		assert.IsType(t, NoAuthRequester{}, NewTokenRequester(config.Auth{Mode: "none"}))
+	})
+}
+
+func TestLocalRequesters(t *testing.T) {
+	t.Run("should not cache a static token", func(t *testing.T) {
+		tokenCache.Invalidate()
+
+		token, source, err := FetchToken(context.Background(), StaticTokenRequester{Token: "static_access_token"})
+
+		assert.NoError(t, err)
+		assert.Equal(t, "static_access_token", token.AccessToken)
+		assert.Equal(t, TokenLocal, source)
+		assert.True(t, tokenCache.expiry().IsZero())
+	})
+
+	t.Run("should read the token file on every call", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "token")
+		assert.NoError(t, os.WriteFile(path, []byte("first_access_token\n"), 0o600))
+		requester := FileTokenRequester{Path: path}
+
+		first, _, err := FetchToken(context.Background(), requester)
+		assert.NoError(t, err)
+		assert.NoError(t, os.WriteFile(path, []byte("rotated_access_token\n"), 0o600))
+		second, _, err := FetchToken(context.Background(), requester)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "first_access_token", first.AccessToken)
+		assert.Equal(t, "rotated_access_token", second.AccessToken)
+	})
+
+	t.Run("should fail when the token file is missing", func(t *testing.T) {
+		_, _, err := FetchToken(context.Background(), FileTokenRequester{Path: "/nonexistent/token"})
+
+		assert.Error(t, err)
+	})
+
+	t.Run("should return no token without authentication", func(t *testing.T) {
+		token, _, err := FetchToken(context.Background(), NoAuthRequester{})
+
+		assert.NoError(t, err)
+		assert.Empty(t, token.AccessToken)
+	})
+}
+
+func TestOAuth2TokenRequester(t *testing.T) {
+	t.Run("should request and cache a token with the client credentials", func(t *testing.T) {
+		var requests atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			assert.NoError(t, r.ParseForm())
+			assert.Equal(t, "client_credentials", r.PostForm.Get("grant_type"))
+			assert.Equal(t, "deployer", r.PostForm.Get("client_id"))
+			assert.Equal(t, "s3cr3t", r.PostForm.Get("client_secret"))
+			assert.Equal(t, "deploy status", r.PostForm.Get("scope"))
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(JWT{AccessToken: "oauth2_access_token", ExpiresIn: 900})
+		}))
+		defer server.Close()
+		tokenCache.Invalidate()
+		requester := OAuth2TokenRequester{TokenURL: server.URL, ClientID: "deployer", ClientSecret: "s3cr3t", Scopes: []string{"deploy", "status"}}
+
+		token, source, err := FetchToken(context.Background(), requester)
+		assert.NoError(t, err)
+		assert.Equal(t, TokenFresh, source)
+		_, source, _ = FetchToken(context.Background(), requester)
+
+		assert.Equal(t, "oauth2_access_token", token.AccessToken)
+		assert.Equal(t, TokenCached, source)
+		assert.Equal(t, int32(1), requests.Load())
+	})
+}
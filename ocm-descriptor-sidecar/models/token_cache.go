@@ -0,0 +1,311 @@
+/*
+  OCM-DESCRIPTOR-SIDECAR
+  Copyright © 2022-2024 EVIDEN
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+  This work has received funding from the European Union's HORIZON research
+  and innovation programme under grant agreement No. 101070177.
+*/
+
+package models
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// tokenRequestTimeout bounds a token request, which is detached from the callers so
+// that one giving up does not fail the others
+const tokenRequestTimeout = 30 * time.Second
+
+// TokenCache caches the tokens by client ID and collapses the concurrent fetches of
+// the same token into a single request. It is safe for concurrent use.
+type TokenCache struct {
+	mu sync.Mutex
+	// expirySkew and refreshRatio apply to the tokens stored from then on
+	expirySkew   time.Duration
+	refreshRatio float64
+	tokens       map[string]CachedToken
+	// fetches holds the in-flight requests by client ID
+	fetches map[string]*tokenFetch
+	// refreshes holds the timers renewing the fetched tokens at their refresh time
+	refreshes map[string]*time.Timer
+	// results holds the outcome of the last token request by client ID
+	results map[string]tokenResult
+	// generation is increased by Invalidate so that the requests in flight at that
+	// time do not store their token
+	generation uint64
+}
+
+// CachedToken is a token along with when to renew it
+type CachedToken struct {
+	Token JWT
+	// ExpiryTime is when the token stops being used, the expiry skew before it actually expires
+	ExpiryTime time.Time
+	// RefreshTime is when the token is renewed in the background
+	RefreshTime time.Time
+	// RefreshExpiryTime is when the refresh token stops being used, zero when it does not expire
+	RefreshExpiryTime time.Time
+}
+
+// refreshToken returns the refresh token if it can still be used, an empty string otherwise
+func (t CachedToken) refreshToken(now time.Time) string {
+	if t.RefreshExpiryTime.IsZero() || now.Before(t.RefreshExpiryTime) {
+		return t.Token.RefreshToken
+	}
+	return ""
+}
+
+// tokenResult is the outcome of a token request
+type tokenResult struct {
+	err error
+	at  time.Time
+}
+
+// tokenFetch is a token request shared by every caller asking for the same token
+type tokenFetch struct {
+	done  chan struct{}
+	token JWT
+	err   error
+}
+
+func NewTokenCache() *TokenCache {
+	return &TokenCache{
+		tokens:    make(map[string]CachedToken),
+		fetches:   make(map[string]*tokenFetch),
+		refreshes: make(map[string]*time.Timer),
+		results:   make(map[string]tokenResult),
+	}
+}
+
+// Configure sets how long before their expiry the tokens stop being used, and after
+// which fraction of their lifetime they are renewed in the background, 0 disabling it
+func (c *TokenCache) Configure(expirySkew time.Duration, refreshRatio float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expirySkew = expirySkew
+	c.refreshRatio = refreshRatio
+}
+
+// Get returns the cached token of the client unless it expired
+func (c *TokenCache) Get(key string) (JWT, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cachedToken, ok := c.tokens[key]
+	if !ok || !time.Now().Before(cachedToken.ExpiryTime) {
+		return JWT{}, false
+	}
+	return cachedToken.Token, true
+}
+
+// Store caches the token of the client until it expires
+func (c *TokenCache) Store(key string, token JWT) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.store(key, token, time.Now(), nil)
+}
+
+// store caches the token of the client. When request is set and the token has a
+// refresh time before its expiry, a timer renews it with request at that time, so that
+// it is renewed even when no caller asks for it. It must be called with the lock held.
+func (c *TokenCache) store(key string, token JWT, now time.Time, request func(ctx context.Context, refreshToken string) (JWT, error)) {
+	c.stopRefresh(key)
+	cachedToken := c.newCachedToken(token, now)
+	c.tokens[key] = cachedToken
+	if request == nil || !cachedToken.RefreshTime.Before(cachedToken.ExpiryTime) {
+		return
+	}
+
+	generation := c.generation
+	c.refreshes[key] = time.AfterFunc(cachedToken.RefreshTime.Sub(now), func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		// the token was replaced, evicted or invalidated in the meantime
+		current, ok := c.tokens[key]
+		if c.generation != generation || !ok || current.Token.AccessToken != token.AccessToken {
+			return
+		}
+		delete(c.refreshes, key)
+		// the refresh belongs to no run, it does not carry the values of the one that fetched the token
+		c.startFetch(context.Background(), key, current.refreshToken(time.Now()), request)
+	})
+}
+
+// stopRefresh stops the timer renewing the token of the client, if any. It must be
+// called with the lock held.
+func (c *TokenCache) stopRefresh(key string) {
+	if timer, ok := c.refreshes[key]; ok {
+		timer.Stop()
+		delete(c.refreshes, key)
+	}
+}
+
+// newCachedToken computes when to renew a token received at now. The expiry skew is
+// capped to half the lifetime so that short-lived tokens are still used.
+func (c *TokenCache) newCachedToken(token JWT, now time.Time) CachedToken {
+	lifetime := time.Duration(token.ExpiresIn) * time.Second
+	cachedToken := CachedToken{
+		Token:      token,
+		ExpiryTime: now.Add(lifetime - min(c.expirySkew, lifetime/2)),
+	}
+	cachedToken.RefreshTime = cachedToken.ExpiryTime
+	if c.refreshRatio > 0 {
+		if refresh := now.Add(time.Duration(float64(lifetime) * c.refreshRatio)); refresh.Before(cachedToken.ExpiryTime) {
+			cachedToken.RefreshTime = refresh
+		}
+	}
+	// Keycloak reports 0 for the refresh tokens that do not expire, e.g. offline tokens
+	if token.RefreshExpiresIn > 0 {
+		refreshLifetime := time.Duration(token.RefreshExpiresIn) * time.Second
+		cachedToken.RefreshExpiryTime = now.Add(refreshLifetime - min(c.expirySkew, refreshLifetime/2))
+	}
+	return cachedToken
+}
+
+// Invalidate drops every cached token. The requests in flight complete for their
+// callers but their tokens are not cached.
+func (c *TokenCache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.refreshes {
+		c.stopRefresh(key)
+	}
+	c.tokens = make(map[string]CachedToken)
+	c.fetches = make(map[string]*tokenFetch)
+	c.results = make(map[string]tokenResult)
+	c.generation++
+}
+
+// Evict drops the cached token of the client if it is still the given one, and
+// reports whether it did. A token already replaced by another caller is kept.
+func (c *TokenCache) Evict(key, accessToken string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cachedToken, ok := c.tokens[key]
+	if !ok || cachedToken.Token.AccessToken != accessToken {
+		return false
+	}
+	delete(c.tokens, key)
+	c.stopRefresh(key)
+	return true
+}
+
+// record keeps the outcome of a token request of the client made outside of the cache
+func (c *TokenCache) record(key string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.results[key] = tokenResult{err: err, at: time.Now()}
+}
+
+// status reports whether the client can get a token, from its cached token or the outcome
+// of its last request. known is false when no token was requested yet, or when the last
+// request failed more than retryAfter before now, to tell that a new request is needed.
+func (c *TokenCache) status(key string, now time.Time, retryAfter time.Duration) (known bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cachedToken, ok := c.tokens[key]; ok && now.Before(cachedToken.ExpiryTime) {
+		return true, nil
+	}
+	result, ok := c.results[key]
+	if !ok || (result.err != nil && now.Sub(result.at) >= retryAfter) {
+		return false, nil
+	}
+	return true, result.err
+}
+
+// expiry returns when the first cached token stops being used, the zero time when there is none
+func (c *TokenCache) expiry() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var expiry time.Time
+	for _, cachedToken := range c.tokens {
+		if expiry.IsZero() || cachedToken.ExpiryTime.Before(expiry) {
+			expiry = cachedToken.ExpiryTime
+		}
+	}
+	return expiry
+}
+
+// Fetch returns the cached token of the client, or requests a new one. The callers
+// asking for the same token while it is requested share the request, each of them
+// waits until its own ctx is cancelled. Failures are not cached.
+//
+// The fetched tokens are renewed in the background with request at their refresh
+// time. Should that renewal fail, the token is still returned once its refresh time is
+// reached while a new one is requested in the background. request receives the
+// refresh token of the previous token when it can still be used.
+func (c *TokenCache) Fetch(ctx context.Context, key string, request func(ctx context.Context, refreshToken string) (JWT, error)) (JWT, TokenSource, error) {
+	c.mu.Lock()
+	now := time.Now()
+	cachedToken, ok := c.tokens[key]
+	if ok && now.Before(cachedToken.ExpiryTime) {
+		if !now.Before(cachedToken.RefreshTime) {
+			c.startFetch(ctx, key, cachedToken.refreshToken(now), request)
+		}
+		c.mu.Unlock()
+		return cachedToken.Token, TokenCached, nil
+	}
+	fetch := c.startFetch(ctx, key, cachedToken.refreshToken(now), request)
+	c.mu.Unlock()
+
+	select {
+	case <-fetch.done:
+		return fetch.token, TokenFresh, fetch.err
+	case <-ctx.Done():
+		return JWT{}, "", ctx.Err()
+	}
+}
+
+// startFetch returns the in-flight request of the client, starting one if there is
+// none. It must be called with the lock held.
+func (c *TokenCache) startFetch(ctx context.Context, key, refreshToken string, request func(ctx context.Context, refreshToken string) (JWT, error)) *tokenFetch {
+	if fetch, ok := c.fetches[key]; ok {
+		return fetch
+	}
+	fetch := &tokenFetch{done: make(chan struct{})}
+	c.fetches[key] = fetch
+	go c.request(ctx, key, refreshToken, fetch, c.generation, request)
+	return fetch
+}
+
+// request runs the request of fetch and caches its token unless the cache was
+// invalidated in the meantime
+func (c *TokenCache) request(ctx context.Context, key, refreshToken string, fetch *tokenFetch, generation uint64, request func(ctx context.Context, refreshToken string) (JWT, error)) {
+	// the values of ctx, such as the trace and the run ID, are kept for the logs
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), tokenRequestTimeout)
+	defer cancel()
+
+	fetch.token, fetch.err = request(ctx, refreshToken)
+
+	c.mu.Lock()
+	if c.generation == generation {
+		delete(c.fetches, key)
+		c.results[key] = tokenResult{err: fetch.err, at: time.Now()}
+		if fetch.err == nil {
+			c.store(key, fetch.token, time.Now(), request)
+		}
+	}
+	c.mu.Unlock()
+	close(fetch.done)
+}
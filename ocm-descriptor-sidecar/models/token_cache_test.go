@@ -0,0 +1,334 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenCacheFetch(t *testing.T) {
+	t.Run("should request the token once for concurrent callers", func(t *testing.T) {
+		cache := NewTokenCache()
+		var requests atomic.Int32
+		release := make(chan struct{})
+		request := func(ctx context.Context, refreshToken string) (JWT, error) {
+			requests.Add(1)
+			<-release
+			return JWT{AccessToken: "mocked_access_token", ExpiresIn: 900}, nil
+		}
+
+		var wg sync.WaitGroup
+		tokens := make([]JWT, 20)
+		for i := range tokens {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				tokens[i], _, _ = cache.Fetch(context.Background(), "sidecar", request)
+			}()
+		}
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		assert.Equal(t, int32(1), requests.Load())
+		for _, token := range tokens {
+			assert.Equal(t, "mocked_access_token", token.AccessToken)
+		}
+		_, source, _ := cache.Fetch(context.Background(), "sidecar", request)
+		assert.Equal(t, TokenCached, source)
+	})
+
+	t.Run("should not cache failures", func(t *testing.T) {
+		cache := NewTokenCache()
+		var requests atomic.Int32
+		request := func(ctx context.Context, refreshToken string) (JWT, error) {
+			if requests.Add(1) == 1 {
+				return JWT{}, assert.AnError
+			}
+			return JWT{AccessToken: "mocked_access_token", ExpiresIn: 900}, nil
+		}
+
+		_, _, err := cache.Fetch(context.Background(), "sidecar", request)
+		assert.ErrorIs(t, err, assert.AnError)
+		token, source, err := cache.Fetch(context.Background(), "sidecar", request)
+
+		assert.NoError(t, err)
+		assert.Equal(t, TokenFresh, source)
+		assert.Equal(t, "mocked_access_token", token.AccessToken)
+	})
+
+	t.Run("should let a caller give up without failing the others", func(t *testing.T) {
+		cache := NewTokenCache()
+		release := make(chan struct{})
+		request := func(ctx context.Context, refreshToken string) (JWT, error) {
+			<-release
+			return JWT{AccessToken: "mocked_access_token", ExpiresIn: 900}, nil
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancelled := make(chan error)
+		go func() {
+			_, _, err := cache.Fetch(ctx, "sidecar", request)
+			cancelled <- err
+		}()
+		done := make(chan JWT)
+		go func() {
+			token, _, _ := cache.Fetch(context.Background(), "sidecar", request)
+			done <- token
+		}()
+		cancel()
+		assert.ErrorIs(t, <-cancelled, context.Canceled)
+
+		close(release)
+		assert.Equal(t, "mocked_access_token", (<-done).AccessToken)
+	})
+
+	t.Run("should not cache the token of a request made before Invalidate", func(t *testing.T) {
+		cache := NewTokenCache()
+		release := make(chan struct{})
+		request := func(ctx context.Context, refreshToken string) (JWT, error) {
+			<-release
+			return JWT{AccessToken: "stale_access_token", ExpiresIn: 900}, nil
+		}
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			cache.Fetch(context.Background(), "sidecar", request)
+		}()
+		time.Sleep(10 * time.Millisecond)
+		cache.Invalidate()
+		close(release)
+		<-done
+
+		_, ok := cache.Get("sidecar")
+		assert.False(t, ok)
+	})
+}
+
+func TestTokenCacheEvict(t *testing.T) {
+	t.Run("should evict the rejected token", func(t *testing.T) {
+		cache := NewTokenCache()
+		cache.Store("sidecar", JWT{AccessToken: "rejected_access_token", ExpiresIn: 900})
+
+		assert.True(t, cache.Evict("sidecar", "rejected_access_token"))
+		_, ok := cache.Get("sidecar")
+		assert.False(t, ok)
+	})
+
+	t.Run("should keep a token that already replaced the rejected one", func(t *testing.T) {
+		cache := NewTokenCache()
+		cache.Store("sidecar", JWT{AccessToken: "new_access_token", ExpiresIn: 900})
+
+		assert.False(t, cache.Evict("sidecar", "rejected_access_token"))
+		token, _ := cache.Get("sidecar")
+		assert.Equal(t, "new_access_token", token.AccessToken)
+	})
+}
+
+func TestTokenCacheExpiry(t *testing.T) {
+	now := time.Now()
+	cache := NewTokenCache()
+	cache.Configure(30*time.Second, 0.8)
+
+	t.Run("should stop using the token the skew margin before it expires", func(t *testing.T) {
+		cachedToken := cache.newCachedToken(JWT{ExpiresIn: 300, RefreshToken: "refresh", RefreshExpiresIn: 1800}, now)
+
+		assert.Equal(t, now.Add(270*time.Second), cachedToken.ExpiryTime)
+		assert.Equal(t, now.Add(240*time.Second), cachedToken.RefreshTime)
+		assert.Equal(t, now.Add(1770*time.Second), cachedToken.RefreshExpiryTime)
+		assert.Equal(t, "refresh", cachedToken.refreshToken(now.Add(time.Hour/2-time.Minute)))
+		assert.Empty(t, cachedToken.refreshToken(now.Add(time.Hour/2)))
+	})
+
+	t.Run("should cap the skew margin to half the lifetime", func(t *testing.T) {
+		cachedToken := cache.newCachedToken(JWT{ExpiresIn: 20}, now)
+
+		assert.Equal(t, now.Add(10*time.Second), cachedToken.ExpiryTime)
+		assert.Equal(t, cachedToken.ExpiryTime, cachedToken.RefreshTime)
+	})
+}
+
+func TestTokenCacheRefresh(t *testing.T) {
+	t.Run("should return the cached token while renewing it in the background", func(t *testing.T) {
+		cache := NewTokenCache()
+		cache.Configure(0, 0.5)
+		cache.Store("sidecar", JWT{AccessToken: "old_access_token", ExpiresIn: 900, RefreshToken: "refresh"})
+		cache.mu.Lock()
+		cachedToken := cache.tokens["sidecar"]
+		cachedToken.RefreshTime = time.Now()
+		cache.tokens["sidecar"] = cachedToken
+		cache.mu.Unlock()
+
+		refreshTokens := make(chan string, 1)
+		request := func(ctx context.Context, refreshToken string) (JWT, error) {
+			refreshTokens <- refreshToken
+			return JWT{AccessToken: "new_access_token", ExpiresIn: 900}, nil
+		}
+		token, source, err := cache.Fetch(context.Background(), "sidecar", request)
+
+		assert.NoError(t, err)
+		assert.Equal(t, TokenCached, source)
+		assert.Equal(t, "old_access_token", token.AccessToken)
+		assert.Equal(t, "refresh", <-refreshTokens)
+		assert.Eventually(t, func() bool {
+			token, _ := cache.Get("sidecar")
+			return token.AccessToken == "new_access_token"
+		}, time.Second, 5*time.Millisecond)
+	})
+}
+
+func TestTokenCacheScheduledRefresh(t *testing.T) {
+	// stored as if it had been fetched a moment before its refresh time
+	storeFetched := func(cache *TokenCache, request func(ctx context.Context, refreshToken string) (JWT, error)) {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		cache.store("sidecar", JWT{AccessToken: "old_access_token", ExpiresIn: 2, RefreshToken: "refresh"}, time.Now().Add(-980*time.Millisecond), request)
+	}
+
+	t.Run("should renew the token at its refresh time without being asked for it", func(t *testing.T) {
+		cache := NewTokenCache()
+		cache.Configure(0, 0.5)
+		refreshTokens := make(chan string, 1)
+		storeFetched(cache, func(ctx context.Context, refreshToken string) (JWT, error) {
+			refreshTokens <- refreshToken
+			return JWT{AccessToken: "new_access_token", ExpiresIn: 900}, nil
+		})
+
+		assert.Equal(t, "refresh", <-refreshTokens)
+		assert.Eventually(t, func() bool {
+			token, _ := cache.Get("sidecar")
+			return token.AccessToken == "new_access_token"
+		}, time.Second, 5*time.Millisecond)
+		cache.mu.Lock()
+		assert.Contains(t, cache.refreshes, "sidecar")
+		cache.mu.Unlock()
+	})
+
+	t.Run("should not renew an invalidated or evicted token", func(t *testing.T) {
+		cache := NewTokenCache()
+		cache.Configure(0, 0.5)
+		var requests atomic.Int32
+		request := func(ctx context.Context, refreshToken string) (JWT, error) {
+			requests.Add(1)
+			return JWT{AccessToken: "new_access_token", ExpiresIn: 900}, nil
+		}
+
+		storeFetched(cache, request)
+		cache.Invalidate()
+		storeFetched(cache, request)
+		assert.True(t, cache.Evict("sidecar", "old_access_token"))
+		time.Sleep(50 * time.Millisecond)
+
+		assert.Equal(t, int32(0), requests.Load())
+	})
+}
+
+// refreshingRequester counts the token requests and fails the refreshes when told to
+type refreshingRequester struct {
+	refreshErr error
+	refreshes  atomic.Int32
+	requests   atomic.Int32
+}
+
+func (r *refreshingRequester) RequestNewToken(ctx context.Context) (JWT, error) {
+	r.requests.Add(1)
+	return JWT{AccessToken: "new_access_token"}, nil
+}
+
+func (r *refreshingRequester) RefreshToken(ctx context.Context, refreshToken string) (JWT, error) {
+	r.refreshes.Add(1)
+	if r.refreshErr != nil {
+		return JWT{}, r.refreshErr
+	}
+	return JWT{AccessToken: "refreshed_access_token"}, nil
+}
+
+func TestRequestToken(t *testing.T) {
+	t.Run("should use the refresh token when there is one", func(t *testing.T) {
+		requester := &refreshingRequester{}
+		token, err := requestToken(context.Background(), requester, "refresh")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "refreshed_access_token", token.AccessToken)
+		assert.Equal(t, int32(0), requester.requests.Load())
+	})
+
+	t.Run("should fall back to a new token when the refresh fails", func(t *testing.T) {
+		requester := &refreshingRequester{refreshErr: assert.AnError}
+		token, err := requestToken(context.Background(), requester, "refresh")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "new_access_token", token.AccessToken)
+		assert.Equal(t, int32(1), requester.refreshes.Load())
+	})
+
+	t.Run("should request a new token without a refresh token", func(t *testing.T) {
+		requester := &refreshingRequester{}
+		requestToken(context.Background(), requester, "")
+
+		assert.Equal(t, int32(0), requester.refreshes.Load())
+		assert.Equal(t, int32(1), requester.requests.Load())
+	})
+}
+
+func TestKeycloakRefreshToken(t *testing.T) {
+	t.Run("should send the refresh token grant", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.NoError(t, r.ParseForm())
+			assert.Equal(t, "refresh_token", r.PostForm.Get("grant_type"))
+			assert.Equal(t, "refresh", r.PostForm.Get("refresh_token"))
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(JWT{AccessToken: "refreshed_access_token", ExpiresIn: 900})
+		}))
+		defer server.Close()
+
+		originalTokenEndpoint := endpoints.TokenEndpoint
+		endpoints.TokenEndpoint = server.URL
+		defer func() { endpoints.TokenEndpoint = originalTokenEndpoint }()
+
+		token, err := KeycloakTokenRequester{}.RefreshToken(context.Background(), "refresh")
+
+		assert.NoError(t, err)
+		assert.Equal(t, "refreshed_access_token", token.AccessToken)
+	})
+}
+
+func TestFetchTokenConcurrently(t *testing.T) {
+	t.Run("should send a single token request for concurrent runs", func(t *testing.T) {
+		var requests atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+			time.Sleep(20 * time.Millisecond)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(JWT{AccessToken: "mocked_access_token", ExpiresIn: 900})
+		}))
+		defer server.Close()
+
+		originalTokenEndpoint := endpoints.TokenEndpoint
+		endpoints.TokenEndpoint = server.URL + "/realms/icos-dev/protocol/openid-connect/token"
+		defer func() { endpoints.TokenEndpoint = originalTokenEndpoint }()
+		tokenCache.Invalidate()
+
+		var wg sync.WaitGroup
+		for range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				token, err := FetchKeycloakToken(context.Background(), KeycloakTokenRequester{})
+				assert.NoError(t, err)
+				assert.Equal(t, "mocked_access_token", token.AccessToken)
+			}()
+		}
+		wg.Wait()
+
+		assert.Equal(t, int32(1), requests.Load())
+	})
+}
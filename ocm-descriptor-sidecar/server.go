@@ -21,7 +21,19 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"icos/server/ocm-descriptor-sidecar/config"
 	"icos/server/ocm-descriptor-sidecar/controllers"
+	"icos/server/ocm-descriptor-sidecar/utils/logs"
+	"icos/server/ocm-descriptor-sidecar/utils/redact"
+	"icos/server/ocm-descriptor-sidecar/utils/tracing"
+	"icos/server/ocm-descriptor-sidecar/utils/watch"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
 )
 
 var server = controllers.Server{}
@@ -34,6 +46,84 @@ func Init() {
 }
 
 func Run() {
-	server.Init()
-	server.Run()
+	// stop scheduling on SIGINT/SIGTERM, e.g. when Kubernetes restarts the pod
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		// list every problem instead of stopping at the first one
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, problem := range joined.Unwrap() {
+				logs.Logger.Error("invalid configuration", "error", problem)
+			}
+			os.Exit(1)
+		}
+		logs.Fatal(logs.Logger, "invalid configuration", "error", err)
+	}
+	apply(nil, cfg)
+
+	shutdownTracing, err := tracing.Setup(ctx, tracing.Config{
+		Endpoint:    cfg.Tracing.Endpoint,
+		ServiceName: cfg.Tracing.ServiceName,
+	})
+	if err != nil {
+		logs.Fatal(logs.Logger, "cannot set up tracing", "error", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	server.Init(cfg)
+	go reloadLoop(ctx, cfg)
+	server.Run(ctx)
+}
+
+// apply applies the settings that are not owned by the scheduler. The level is only
+// set when it changed from prev, so that a reload keeps the one set on /admin/log-level.
+func apply(prev, cfg *config.Config) {
+	if prev == nil || prev.Log.Level != cfg.Log.Level {
+		logs.SetLevel(cfg.Log.Level)
+	}
+	logs.SetFormat(cfg.Log.Format)
+	logs.SetHTTPBodies(cfg.Log.HTTPBodies)
+	redact.Configure(cfg.Log.Redact.Headers, cfg.Log.Redact.FormFields, cfg.Log.Redact.JSONKeys)
+}
+
+// reloadLoop reloads the configuration on SIGHUP and when one of its files changes,
+// keeping the current one when the new one is invalid
+func reloadLoop(ctx context.Context, cfg *config.Config) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var current atomic.Pointer[config.Config]
+	current.Store(cfg)
+	var changes <-chan struct{}
+	if cfg.WatchInterval > 0 {
+		changes = watch.Files(ctx, cfg.WatchInterval, func() []string { return current.Load().WatchedFiles() })
+	}
+	for {
+		var reason string
+		select {
+		case <-hup:
+			reason = "SIGHUP"
+		case <-changes:
+			reason = "file changed"
+		case <-ctx.Done():
+			return
+		}
+		next, err := config.Load(os.Args[1:], os.Getenv)
+		if err == nil {
+			err = server.Reload(next)
+		}
+		if err != nil {
+			logs.Logger.Error("configuration not reloaded", "reason", reason, "error", err)
+			continue
+		}
+		apply(current.Load(), next)
+		current.Store(next)
+		logs.Logger.Info("configuration reloaded", "reason", reason)
+	}
 }
@@ -0,0 +1,207 @@
+/*
+  OCM-DESCRIPTOR-SIDECAR
+  Copyright © 2022-2024 EVIDEN
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+  This work has received funding from the European Union's HORIZON research
+  and innovation programme under grant agreement No. 101070177.
+*/
+
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrOpen is returned by Allow while the breaker rejects calls
+var ErrOpen = errors.New("circuit breaker is open")
+
+type State int
+
+const (
+	Closed State = iota
+	Open
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Settings configures when the breaker opens and how it recovers
+type Settings struct {
+	// FailureThreshold is the number of consecutive failures that opens the breaker
+	FailureThreshold int `yaml:"failureThreshold"`
+	// OpenTimeout is how long the breaker stays open before letting probe calls through
+	OpenTimeout time.Duration `yaml:"openTimeout"`
+	// HalfOpenMaxCalls is the number of probe calls allowed, and the number of
+	// successes required to close the breaker again
+	HalfOpenMaxCalls int `yaml:"halfOpenMaxCalls"`
+}
+
+// DefaultSettings returns the settings used when nothing is configured
+func DefaultSettings() Settings {
+	return Settings{
+		FailureThreshold: 5,
+		OpenTimeout:      30 * time.Second,
+		HalfOpenMaxCalls: 1,
+	}
+}
+
+// Validate checks that the settings can be used
+func (s Settings) Validate() error {
+	switch {
+	case s.FailureThreshold < 1:
+		return fmt.Errorf("failure threshold must be at least 1, got %d", s.FailureThreshold)
+	case s.OpenTimeout <= 0:
+		return fmt.Errorf("open timeout must be positive, got %s", s.OpenTimeout)
+	case s.HalfOpenMaxCalls < 1:
+		return fmt.Errorf("half-open max calls must be at least 1, got %d", s.HalfOpenMaxCalls)
+	}
+	return nil
+}
+
+// Status is a snapshot of the breaker
+type Status struct {
+	Name                string    `json:"name"`
+	State               State     `json:"state"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastStateChange     time.Time `json:"last_state_change"`
+}
+
+// Breaker is a closed/open/half-open circuit breaker safe for concurrent use
+type Breaker struct {
+	name     string
+	settings Settings
+	// OnStateChange, if set, is called on every transition while the breaker is locked
+	OnStateChange func(name string, from, to State)
+
+	mu            sync.Mutex
+	state         State
+	failures      int
+	halfOpenCalls int
+	successes     int
+	changedAt     time.Time
+}
+
+func New(name string, settings Settings) *Breaker {
+	return &Breaker{name: name, settings: settings, changedAt: time.Now()}
+}
+
+// Settings returns the settings the breaker was created with
+func (b *Breaker) Settings() Settings {
+	return b.settings
+}
+
+// Allow reports whether a call may proceed, it returns ErrOpen when it may not.
+// Every allowed call must be followed by Success, Failure or Release.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open {
+		if time.Since(b.changedAt) < b.settings.OpenTimeout {
+			return ErrOpen
+		}
+		b.setState(HalfOpen)
+	}
+	if b.state == HalfOpen {
+		if b.halfOpenCalls >= b.settings.HalfOpenMaxCalls {
+			return ErrOpen
+		}
+		b.halfOpenCalls++
+	}
+	return nil
+}
+
+// Success records a successful call
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	if b.state == HalfOpen {
+		b.successes++
+		if b.successes >= b.settings.HalfOpenMaxCalls {
+			b.setState(Closed)
+		}
+	}
+}
+
+// Failure records a failed call
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.state == HalfOpen || b.failures >= b.settings.FailureThreshold {
+		b.setState(Open)
+	}
+}
+
+// Release gives back an allowed call that did not reach the upstream, so
+// neither its success nor its failure can be recorded
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == HalfOpen && b.halfOpenCalls > 0 {
+		b.halfOpenCalls--
+	}
+}
+
+// Status returns a snapshot of the breaker
+func (b *Breaker) Status() Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return Status{
+		Name:                b.name,
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		LastStateChange:     b.changedAt,
+	}
+}
+
+func (b *Breaker) setState(state State) {
+	if b.state == state {
+		return
+	}
+	from := b.state
+	b.state = state
+	b.changedAt = time.Now()
+	b.halfOpenCalls = 0
+	b.successes = 0
+	if state == Closed {
+		b.failures = 0
+	}
+	if b.OnStateChange != nil {
+		b.OnStateChange(b.name, from, state)
+	}
+}
@@ -0,0 +1,72 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreaker(t *testing.T) {
+	settings := Settings{FailureThreshold: 2, OpenTimeout: 10 * time.Millisecond, HalfOpenMaxCalls: 1}
+
+	t.Run("should open after consecutive failures", func(t *testing.T) {
+		b := New("test", settings)
+		for i := 0; i < 2; i++ {
+			assert.NoError(t, b.Allow())
+			b.Failure()
+		}
+
+		assert.Equal(t, Open, b.Status().State)
+		assert.ErrorIs(t, b.Allow(), ErrOpen)
+	})
+
+	t.Run("should reset failures on success", func(t *testing.T) {
+		b := New("test", settings)
+		b.Failure()
+		b.Success()
+		b.Failure()
+
+		assert.Equal(t, Closed, b.Status().State)
+	})
+
+	t.Run("should close after a successful probe", func(t *testing.T) {
+		var transitions []State
+		b := New("test", settings)
+		b.OnStateChange = func(name string, from, to State) { transitions = append(transitions, to) }
+		b.Failure()
+		b.Failure()
+		time.Sleep(settings.OpenTimeout)
+
+		assert.NoError(t, b.Allow())
+		assert.ErrorIs(t, b.Allow(), ErrOpen)
+		b.Success()
+
+		assert.Equal(t, Closed, b.Status().State)
+		assert.Equal(t, []State{Open, HalfOpen, Closed}, transitions)
+	})
+
+	t.Run("should reopen after a failed probe", func(t *testing.T) {
+		b := New("test", settings)
+		b.Failure()
+		b.Failure()
+		time.Sleep(settings.OpenTimeout)
+
+		assert.NoError(t, b.Allow())
+		b.Failure()
+
+		assert.Equal(t, Open, b.Status().State)
+	})
+
+	t.Run("should give back released probes", func(t *testing.T) {
+		b := New("test", settings)
+		b.Failure()
+		b.Failure()
+		time.Sleep(settings.OpenTimeout)
+
+		assert.NoError(t, b.Allow())
+		b.Release()
+
+		assert.NoError(t, b.Allow())
+	})
+}
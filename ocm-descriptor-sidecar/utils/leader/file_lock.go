@@ -0,0 +1,149 @@
+/*
+  OCM-DESCRIPTOR-SIDECAR
+  Copyright © 2022-2024 EVIDEN
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+  This work has received funding from the European Union's HORIZON research
+  and innovation programme under grant agreement No. 101070177.
+*/
+
+package leader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+var fileResource = schema.GroupResource{Resource: "file"}
+
+// FileLock is a resourcelock.Interface storing the leader election record in a
+// file on a volume shared by the replicas. Writes are serialized with an
+// advisory lock on a sibling ".lock" file and only succeed if the record did
+// not change since it was last observed, like a resourceVersion check.
+type FileLock struct {
+	path     string
+	identity string
+
+	mu       sync.Mutex
+	observed []byte
+}
+
+func NewFileLock(path, identity string) *FileLock {
+	return &FileLock{path: path, identity: identity}
+}
+
+// Get returns the election record stored in the file
+func (l *FileLock) Get(ctx context.Context) (*resourcelock.LeaderElectionRecord, []byte, error) {
+	raw, err := os.ReadFile(l.path)
+	if os.IsNotExist(err) || (err == nil && len(raw) == 0) {
+		return nil, nil, apierrors.NewNotFound(fileResource, l.path)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var record resourcelock.LeaderElectionRecord
+	if err := json.Unmarshal(raw, &record); err != nil {
+		return nil, nil, fmt.Errorf("decoding %s: %w", l.path, err)
+	}
+
+	l.mu.Lock()
+	l.observed = raw
+	l.mu.Unlock()
+	return &record, raw, nil
+}
+
+// Create writes the first election record, failing if another replica already did
+func (l *FileLock) Create(ctx context.Context, ler resourcelock.LeaderElectionRecord) error {
+	return l.write(ler, func(current []byte) error {
+		if len(current) > 0 {
+			return apierrors.NewAlreadyExists(fileResource, l.path)
+		}
+		return nil
+	})
+}
+
+// Update replaces the election record, failing if it changed since it was last observed
+func (l *FileLock) Update(ctx context.Context, ler resourcelock.LeaderElectionRecord) error {
+	return l.write(ler, func(current []byte) error {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if !bytes.Equal(current, l.observed) {
+			return apierrors.NewConflict(fileResource, l.path, fmt.Errorf("the record has been modified"))
+		}
+		return nil
+	})
+}
+
+// write stores the record if check accepts the current content of the file
+func (l *FileLock) write(ler resourcelock.LeaderElectionRecord, check func(current []byte) error) error {
+	raw, err := json.Marshal(ler)
+	if err != nil {
+		return err
+	}
+	unlock, err := lockFile(l.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current, err := os.ReadFile(l.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := check(current); err != nil {
+		return err
+	}
+
+	// write then rename so that readers never see a partial record
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), l.path); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.observed = raw
+	l.mu.Unlock()
+	return nil
+}
+
+// RecordEvent logs nothing, the elector callbacks already report leadership changes
+func (l *FileLock) RecordEvent(string) {}
+
+func (l *FileLock) Identity() string {
+	return l.identity
+}
+
+func (l *FileLock) Describe() string {
+	return "file " + l.path
+}
@@ -0,0 +1,125 @@
+/*
+  OCM-DESCRIPTOR-SIDECAR
+  Copyright © 2022-2024 EVIDEN
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+  This work has received funding from the European Union's HORIZON research
+  and innovation programme under grant agreement No. 101070177.
+*/
+
+package leader
+
+import (
+	"context"
+	"icos/server/ocm-descriptor-sidecar/utils/logs"
+	"icos/server/ocm-descriptor-sidecar/utils/metrics"
+	"sync/atomic"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+var logger = logs.Component("leader-election")
+
+// Timings configures how long a lease is held and how often it is renewed.
+// A follower takes over at most LeaseDuration after the leader stopped renewing.
+type Timings struct {
+	LeaseDuration time.Duration `yaml:"leaseDuration"`
+	RenewDeadline time.Duration `yaml:"renewDeadline"`
+	RetryPeriod   time.Duration `yaml:"retryPeriod"`
+}
+
+// DefaultTimings returns the timings used by the Kubernetes control plane components
+func DefaultTimings() Timings {
+	return Timings{
+		LeaseDuration: 15 * time.Second,
+		RenewDeadline: 10 * time.Second,
+		RetryPeriod:   2 * time.Second,
+	}
+}
+
+// Elector campaigns for leadership on a lock and reports whether this replica is the leader
+type Elector struct {
+	elector *leaderelection.LeaderElector
+	lock    resourcelock.Interface
+	leading atomic.Bool
+}
+
+// NewElector creates an elector campaigning on the given lock
+func NewElector(lock resourcelock.Interface, timings Timings) (*Elector, error) {
+	e := &Elector{lock: lock}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   timings.LeaseDuration,
+		RenewDeadline:   timings.RenewDeadline,
+		RetryPeriod:     timings.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            lock.Describe(),
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				e.leading.Store(true)
+				metrics.Leader.Set(1)
+				logger.Info("became the leader", "identity", lock.Identity(), "lock", lock.Describe())
+			},
+			OnStoppedLeading: func() {
+				metrics.Leader.Set(0)
+				if e.leading.Swap(false) {
+					logger.Warn("stopped leading", "identity", lock.Identity(), "lock", lock.Describe())
+				}
+			},
+			OnNewLeader: func(identity string) {
+				if identity != lock.Identity() {
+					logger.Info("new leader elected", "leader", identity, "lock", lock.Describe())
+				}
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	e.elector = elector
+	return e, nil
+}
+
+// Run campaigns until ctx is cancelled, then releases the lock if it holds it.
+// When the leadership is lost, the elector goes back to campaigning.
+func (e *Elector) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		e.elector.Run(ctx)
+	}
+}
+
+// IsLeader reports whether this replica currently holds the lock
+func (e *Elector) IsLeader() bool {
+	return e.leading.Load()
+}
+
+// Identity returns the identity this replica campaigns with
+func (e *Elector) Identity() string {
+	return e.lock.Identity()
+}
+
+// NewLeaseLock returns a lock on the coordination.k8s.io Lease namespace/name
+func NewLeaseLock(client kubernetes.Interface, namespace, name, identity string) resourcelock.Interface {
+	return &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Client:    client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+}
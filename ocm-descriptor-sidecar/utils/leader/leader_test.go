@@ -0,0 +1,81 @@
+package leader
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+var testTimings = Timings{
+	LeaseDuration: 2 * time.Second,
+	RenewDeadline: 1500 * time.Millisecond,
+	RetryPeriod:   100 * time.Millisecond,
+}
+
+// assertSingleLeaderAndTakeover runs two electors, checks that only one of them
+// leads and that the other takes over once the leader stops
+func assertSingleLeaderAndTakeover(t *testing.T, newLock func(identity string) resourcelock.Interface) {
+	first, err := NewElector(newLock("first"), testTimings)
+	require.NoError(t, err)
+	second, err := NewElector(newLock("second"), testTimings)
+	require.NoError(t, err)
+
+	firstCtx, stopFirst := context.WithCancel(context.Background())
+	firstDone := make(chan struct{})
+	go func() {
+		first.Run(firstCtx)
+		close(firstDone)
+	}()
+	require.Eventually(t, first.IsLeader, 5*time.Second, 10*time.Millisecond)
+
+	secondCtx, stopSecond := context.WithCancel(context.Background())
+	defer stopSecond()
+	go second.Run(secondCtx)
+	time.Sleep(3 * testTimings.RetryPeriod)
+	assert.False(t, second.IsLeader())
+
+	stopFirst()
+	<-firstDone
+	assert.False(t, first.IsLeader())
+	assert.Eventually(t, second.IsLeader, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestFileLock(t *testing.T) {
+	t.Run("should elect a single leader and fail over", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "leader.json")
+
+		assertSingleLeaderAndTakeover(t, func(identity string) resourcelock.Interface {
+			return NewFileLock(path, identity)
+		})
+	})
+
+	t.Run("should reject updates of a modified record", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "leader.json")
+		first := NewFileLock(path, "first")
+		second := NewFileLock(path, "second")
+
+		assert.NoError(t, first.Create(context.Background(), resourcelock.LeaderElectionRecord{HolderIdentity: "first"}))
+		assert.Error(t, second.Create(context.Background(), resourcelock.LeaderElectionRecord{HolderIdentity: "second"}))
+
+		_, _, err := second.Get(context.Background())
+		assert.NoError(t, err)
+		assert.NoError(t, first.Update(context.Background(), resourcelock.LeaderElectionRecord{HolderIdentity: "first", LeaderTransitions: 1}))
+		assert.Error(t, second.Update(context.Background(), resourcelock.LeaderElectionRecord{HolderIdentity: "second"}))
+	})
+}
+
+func TestLeaseLock(t *testing.T) {
+	t.Run("should elect a single leader and fail over", func(t *testing.T) {
+		client := fake.NewSimpleClientset()
+
+		assertSingleLeaderAndTakeover(t, func(identity string) resourcelock.Interface {
+			return NewLeaseLock(client, "icos", "ocm-descriptor-sidecar", identity)
+		})
+	})
+}
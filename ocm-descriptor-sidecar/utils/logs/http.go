@@ -0,0 +1,65 @@
+/*
+  OCM-DESCRIPTOR-SIDECAR
+  Copyright © 2022-2024 EVIDEN
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+  This work has received funding from the European Union's HORIZON research
+  and innovation programme under grant agreement No. 101070177.
+*/
+
+package logs
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+
+	"icos/server/ocm-descriptor-sidecar/utils/redact"
+)
+
+// httpBodies tells whether the HTTP dumps include the bodies
+var httpBodies atomic.Bool
+
+// SetHTTPBodies includes or leaves out the bodies of the HTTP dumps
+func SetHTTPBodies(enabled bool) {
+	httpBodies.Store(enabled)
+}
+
+// DebugRequest logs the request at the debug level with its secrets masked, the body
+// is only included when enabled with SetHTTPBodies
+func DebugRequest(logger *slog.Logger, msg string, req *http.Request) {
+	if !logger.Enabled(req.Context(), slog.LevelDebug) {
+		return
+	}
+	dump, err := redact.Default().DumpRequest(req, httpBodies.Load())
+	if err != nil {
+		logger.Debug(msg, "error", err)
+		return
+	}
+	logger.Debug(msg, "dump", dump)
+}
+
+// DebugResponse logs the response like DebugRequest
+func DebugResponse(ctx context.Context, logger *slog.Logger, msg string, resp *http.Response) {
+	if !logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	dump, err := redact.Default().DumpResponse(resp, httpBodies.Load())
+	if err != nil {
+		logger.Debug(msg, "error", err)
+		return
+	}
+	logger.Debug(msg, "dump", dump)
+}
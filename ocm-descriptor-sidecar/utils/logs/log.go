@@ -21,14 +21,159 @@
 package logs
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"strings"
+	"sync/atomic"
+
+	"icos/server/ocm-descriptor-sidecar/utils/redact"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
 )
 
-var Logger *log.Logger
+// Logger is the root structured logger, writing text records at the info level
+// to stdout until SetFormat and SetLevel are called
+var Logger *slog.Logger
+
+var (
+	// level is shared by every handler so that it can be changed at runtime
+	level = new(slog.LevelVar)
+	// useJSON selects the JSON output of the root logger
+	useJSON atomic.Bool
+)
 
 func init() {
+	text, _ := NewHandler(os.Stdout, FormatText)
+	json, _ := NewHandler(os.Stdout, FormatJSON)
+	Logger = slog.New(&formatHandler{text: text, json: json})
+}
+
+// formatHandler sends the records to the text or the JSON handler depending on the
+// current format, so that the loggers derived from Logger follow SetFormat
+type formatHandler struct {
+	text, json slog.Handler
+}
+
+func (h *formatHandler) current() slog.Handler {
+	if useJSON.Load() {
+		return h.json
+	}
+	return h.text
+}
+
+func (h *formatHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return h.current().Enabled(ctx, l)
+}
+
+func (h *formatHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.current().Handle(ctx, r)
+}
+
+func (h *formatHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &formatHandler{text: h.text.WithAttrs(attrs), json: h.json.WithAttrs(attrs)}
+}
+
+func (h *formatHandler) WithGroup(name string) slog.Handler {
+	return &formatHandler{text: h.text.WithGroup(name), json: h.json.WithGroup(name)}
+}
+
+// ParseFormat checks a format name, ignoring case
+func ParseFormat(format string) (string, error) {
+	switch f := strings.ToLower(format); f {
+	case "", FormatText:
+		return FormatText, nil
+	case FormatJSON:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown log format %q, expected text or json", format)
+}
+
+// SetFormat switches every logger to the text or JSON output
+func SetFormat(format string) error {
+	f, err := ParseFormat(format)
+	if err != nil {
+		return err
+	}
+	useJSON.Store(f == FormatJSON)
+	return nil
+}
+
+// NewHandler returns a handler writing to w in the given format, text by default,
+// at the level set with SetLevel. The values of the attributes named like a secret
+// header, form field or JSON key are masked.
+func NewHandler(w io.Writer, format string) (slog.Handler, error) {
+	f, err := ParseFormat(format)
+	if err != nil {
+		return nil, err
+	}
+	options := &slog.HandlerOptions{Level: level, ReplaceAttr: redactAttr}
+	if f == FormatJSON {
+		return slog.NewJSONHandler(w, options), nil
+	}
+	return slog.NewTextHandler(w, options), nil
+}
+
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() != slog.KindGroup && redact.Default().IsSecret(a.Key) {
+		return slog.String(a.Key, redact.Mask)
+	}
+	return a
+}
+
+// Component returns a logger tagging every record with the given component name
+func Component(name string) *slog.Logger {
+	return Logger.With("component", name)
+}
+
+type fieldsKey struct{}
 
-	Logger = log.New(os.Stdout, "[DEPLOY-MANAGER] ", log.Ldate|log.Ltime)
+// WithFields returns a context carrying the given key-value pairs, added by FromContext
+// to the records of any component working for the same request or run
+func WithFields(ctx context.Context, args ...any) context.Context {
+	fields, _ := ctx.Value(fieldsKey{}).([]any)
+	return context.WithValue(ctx, fieldsKey{}, append(fields[:len(fields):len(fields)], args...))
+}
+
+// FromContext returns the logger with the fields carried by ctx
+func FromContext(ctx context.Context, logger *slog.Logger) *slog.Logger {
+	if fields, ok := ctx.Value(fieldsKey{}).([]any); ok {
+		return logger.With(fields...)
+	}
+	return logger
+}
+
+// Level returns the current level name, in lower case
+func Level() string {
+	return strings.ToLower(level.Level().String())
+}
+
+// ParseLevel reads a level name, accepting debug, info, warn or error
+func ParseLevel(name string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(name)); err != nil {
+		return l, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", name)
+	}
+	return l, nil
+}
+
+// SetLevel changes the level of every logger
+func SetLevel(name string) error {
+	l, err := ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	level.Set(l)
+	return nil
+}
 
+// Fatal logs the message at the error level and exits
+func Fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
 }
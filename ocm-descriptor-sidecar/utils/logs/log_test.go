@@ -0,0 +1,80 @@
+package logs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetLevel(t *testing.T) {
+	defer SetLevel("info")
+
+	t.Run("should change the level at runtime", func(t *testing.T) {
+		var out bytes.Buffer
+		handler, _ := NewHandler(&out, FormatText)
+		logger := slog.New(handler)
+
+		logger.Debug("hidden")
+		assert.Empty(t, out.String())
+
+		assert.NoError(t, SetLevel("DEBUG"))
+		assert.Equal(t, "debug", Level())
+		logger.Debug("shown")
+		assert.Contains(t, out.String(), "msg=shown")
+	})
+
+	t.Run("should reject an unknown level", func(t *testing.T) {
+		assert.NoError(t, SetLevel("warn"))
+		assert.Error(t, SetLevel("verbose"))
+		assert.Equal(t, "warn", Level())
+	})
+}
+
+func TestNewHandler(t *testing.T) {
+	t.Run("should write JSON records with the component fields", func(t *testing.T) {
+		var out bytes.Buffer
+		handler, err := NewHandler(&out, "JSON")
+		assert.NoError(t, err)
+
+		slog.New(handler).With("component", "scheduler").Info("run finished", "task", "execute")
+
+		var record map[string]any
+		assert.NoError(t, json.Unmarshal(out.Bytes(), &record))
+		assert.Equal(t, "INFO", record["level"])
+		assert.Equal(t, "run finished", record["msg"])
+		assert.Equal(t, "scheduler", record["component"])
+		assert.Equal(t, "execute", record["task"])
+	})
+
+	t.Run("should mask the attributes named like a secret", func(t *testing.T) {
+		var out bytes.Buffer
+		handler, _ := NewHandler(&out, FormatText)
+
+		slog.New(handler).Info("token fetched", "access_token", "secret", "expires_in", 300)
+
+		assert.NotContains(t, out.String(), "secret")
+		assert.Contains(t, out.String(), "access_token=[REDACTED] expires_in=300")
+	})
+
+	t.Run("should reject an unknown format", func(t *testing.T) {
+		_, err := NewHandler(&bytes.Buffer{}, "xml")
+		assert.Error(t, err)
+	})
+}
+
+func TestFromContext(t *testing.T) {
+	t.Run("should add the context fields to the records", func(t *testing.T) {
+		var out bytes.Buffer
+		handler, _ := NewHandler(&out, FormatText)
+		ctx := WithFields(context.Background(), "task", "execute")
+		ctx = WithFields(ctx, "run_id", "42")
+
+		FromContext(ctx, slog.New(handler)).Info("token fetched")
+
+		assert.Contains(t, out.String(), "task=execute run_id=42")
+	})
+}
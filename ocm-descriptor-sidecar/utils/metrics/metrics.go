@@ -0,0 +1,116 @@
+/*
+  OCM-DESCRIPTOR-SIDECAR
+  Copyright © 2022-2024 EVIDEN
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+  This work has received funding from the European Union's HORIZON research
+  and innovation programme under grant agreement No. 101070177.
+*/
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "ocm_sidecar"
+
+var (
+	// TaskRuns counts the runs of every task by outcome
+	TaskRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "task_runs_total",
+		Help:      "Number of task runs by task and outcome.",
+	}, []string{"task", "outcome"})
+
+	// TaskRunDuration observes the duration of the runs, retries included
+	TaskRunDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "task_run_duration_seconds",
+		Help:      "Duration of the task runs, retries included, by task and outcome.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"task", "outcome"})
+
+	// TaskTicksSkipped counts the ticks that did not start a run
+	TaskTicksSkipped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "task_ticks_skipped_total",
+		Help:      "Number of ticks skipped by task and reason.",
+	}, []string{"task", "reason"})
+
+	// TaskTicksDelayed counts the ticks that fired late
+	TaskTicksDelayed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "task_ticks_delayed_total",
+		Help:      "Number of ticks that fired late by task.",
+	}, []string{"task"})
+
+	// TaskRunsReplaced counts the runs cancelled by the Replace concurrency policy
+	TaskRunsReplaced = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "task_runs_replaced_total",
+		Help:      "Number of in-flight runs cancelled by a newer run by task.",
+	}, []string{"task"})
+
+	// TaskPaused is 1 while a task is paused
+	TaskPaused = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "task_paused",
+		Help:      "Whether the task is paused (1) or scheduled (0).",
+	}, []string{"task"})
+
+	// UpstreamRequestDuration observes every request to the Deployment Manager
+	UpstreamRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "deploy_manager_request_duration_seconds",
+		Help:      "Latency of the Deployment Manager requests by endpoint and status code, 0 for network errors.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint", "code"})
+
+	// CircuitBreakerState is the state of the circuit breakers, 0 closed, 1 open and 2 half-open
+	CircuitBreakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "circuit_breaker_state",
+		Help:      "State of the circuit breaker: 0 closed, 1 open, 2 half-open.",
+	}, []string{"name"})
+
+	// Leader is 1 while this replica may run the tasks
+	Leader = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "leader",
+		Help:      "Whether this replica is the leader (1) or a follower (0).",
+	})
+
+	// TokenFetches counts the token requests sent to the token endpoint by result
+	TokenFetches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "token_fetches_total",
+		Help:      "Number of token requests sent to the token endpoint by result.",
+	}, []string{"result"})
+
+	// TokenCacheRequests counts the token cache lookups by result, hit or miss
+	TokenCacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "token_cache_requests_total",
+		Help:      "Number of token cache lookups by result (hit or miss).",
+	}, []string{"result"})
+
+	// TokenEvictions counts the cached tokens evicted because an upstream rejected them
+	TokenEvictions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "token_evictions_total",
+		Help:      "Number of cached tokens evicted after an upstream rejected them, by status code.",
+	}, []string{"code"})
+)
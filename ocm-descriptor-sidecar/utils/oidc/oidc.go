@@ -0,0 +1,148 @@
+/*
+  OCM-DESCRIPTOR-SIDECAR
+  Copyright © 2022-2024 EVIDEN
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+  This work has received funding from the European Union's HORIZON research
+  and innovation programme under grant agreement No. 101070177.
+*/
+
+// Package oidc resolves the endpoints of an OpenID Connect issuer from its discovery
+// document (OpenID Connect Discovery 1.0)
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"icos/server/ocm-descriptor-sidecar/utils/logs"
+	"icos/server/ocm-descriptor-sidecar/utils/tracing"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// discoveryPath is where the issuers publish their discovery document
+const discoveryPath = "/.well-known/openid-configuration"
+
+var logger = logs.Component("oidc")
+
+// Endpoints is the part of the discovery document of an issuer used by the sidecar
+type Endpoints struct {
+	Issuer                string `json:"issuer"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+	IntrospectionEndpoint string `json:"introspection_endpoint,omitempty"`
+	RevocationEndpoint    string `json:"revocation_endpoint,omitempty"`
+}
+
+// Keycloak returns the endpoints of a Keycloak realm without discovery
+func Keycloak(baseURL, realm string) Endpoints {
+	issuer := strings.TrimSuffix(baseURL, "/") + "/realms/" + realm
+	return Endpoints{
+		Issuer:                issuer,
+		TokenEndpoint:         issuer + "/protocol/openid-connect/token",
+		JWKSURI:               issuer + "/protocol/openid-connect/certs",
+		IntrospectionEndpoint: issuer + "/protocol/openid-connect/token/introspect",
+		RevocationEndpoint:    issuer + "/protocol/openid-connect/revoke",
+	}
+}
+
+// Discover fetches the discovery document of the issuer
+func Discover(ctx context.Context, client *http.Client, issuer string) (Endpoints, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+	req, err := http.NewRequestWithContext(ctx, "GET", issuer+discoveryPath, http.NoBody)
+	if err != nil {
+		return Endpoints{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	req, span := tracing.StartClientSpan(req, "GET openid-configuration")
+	resp, err := client.Do(req)
+	tracing.EndClientSpan(span, resp, err)
+	if err != nil {
+		return Endpoints{}, fmt.Errorf("oidc discovery: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Endpoints{}, fmt.Errorf("oidc discovery: %s returned %s", req.URL, resp.Status)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Endpoints{}, fmt.Errorf("oidc discovery: %w", err)
+	}
+	var endpoints Endpoints
+	if err := json.Unmarshal(b, &endpoints); err != nil {
+		return Endpoints{}, fmt.Errorf("oidc discovery: %w", err)
+	}
+	// the issuer must be the one the document was asked for, see section 4.3 of the specification
+	if strings.TrimSuffix(endpoints.Issuer, "/") != issuer {
+		return Endpoints{}, fmt.Errorf("oidc discovery: issuer %q does not match %q", endpoints.Issuer, issuer)
+	}
+	if endpoints.TokenEndpoint == "" {
+		return Endpoints{}, errors.New("oidc discovery: no token endpoint")
+	}
+	return endpoints, nil
+}
+
+// Provider caches the discovery document of an issuer and fetches it again once it
+// is older than the refresh interval. It is safe for concurrent use.
+type Provider struct {
+	Issuer          string
+	RefreshInterval time.Duration
+	Client          *http.Client
+
+	mu        sync.Mutex
+	endpoints Endpoints
+	fetchedAt time.Time
+}
+
+func NewProvider(issuer string, refreshInterval time.Duration) *Provider {
+	return &Provider{
+		Issuer:          issuer,
+		RefreshInterval: refreshInterval,
+		Client:          &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Endpoints returns the endpoints of the issuer, fetching the discovery document when
+// it is not cached or is due for a refresh. When the refresh fails the cached document
+// is kept until the next refresh, only a first failure is returned.
+func (p *Provider) Endpoints(ctx context.Context) (Endpoints, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.fetchedAt.IsZero() && time.Since(p.fetchedAt) < p.RefreshInterval {
+		return p.endpoints, nil
+	}
+	endpoints, err := Discover(ctx, p.Client, p.Issuer)
+	if err != nil {
+		if p.fetchedAt.IsZero() {
+			return Endpoints{}, err
+		}
+		logs.FromContext(ctx, logger).Warn("cannot refresh the discovery document, keeping the cached one", "issuer", p.Issuer, "error", err)
+		p.fetchedAt = time.Now()
+		return p.endpoints, nil
+	}
+	if endpoints != p.endpoints {
+		logs.FromContext(ctx, logger).Info("discovery document loaded", "issuer", p.Issuer, "token_endpoint", endpoints.TokenEndpoint)
+	}
+	p.endpoints = endpoints
+	p.fetchedAt = time.Now()
+	return endpoints, nil
+}
@@ -0,0 +1,115 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// issuer serves a discovery document naming its own URL as the issuer, or the
+// status returned by fail when it is not 200
+func issuer(t *testing.T, fail func() int) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		assert.Equal(t, "/.well-known/openid-configuration", r.URL.Path)
+		if status := fail(); status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		json.NewEncoder(w).Encode(Endpoints{
+			Issuer:        server.URL,
+			TokenEndpoint: server.URL + "/oauth2/token",
+			JWKSURI:       server.URL + "/oauth2/keys",
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func ok() int { return http.StatusOK }
+
+func TestDiscover(t *testing.T) {
+	t.Run("should read the endpoints of the issuer", func(t *testing.T) {
+		server, _ := issuer(t, ok)
+
+		endpoints, err := Discover(context.Background(), http.DefaultClient, server.URL+"/")
+
+		assert.NoError(t, err)
+		assert.Equal(t, server.URL+"/oauth2/token", endpoints.TokenEndpoint)
+		assert.Equal(t, server.URL+"/oauth2/keys", endpoints.JWKSURI)
+	})
+
+	t.Run("should reject a document of another issuer", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(Endpoints{Issuer: "https://other.example.com", TokenEndpoint: "https://other.example.com/token"})
+		}))
+		defer server.Close()
+
+		_, err := Discover(context.Background(), http.DefaultClient, server.URL)
+
+		assert.ErrorContains(t, err, "does not match")
+	})
+
+	t.Run("should return the status of a failed request", func(t *testing.T) {
+		server, _ := issuer(t, func() int { return http.StatusNotFound })
+
+		_, err := Discover(context.Background(), http.DefaultClient, server.URL)
+
+		assert.ErrorContains(t, err, "404")
+	})
+}
+
+func TestKeycloak(t *testing.T) {
+	endpoints := Keycloak("https://keycloak.example.com/", "icos-dev")
+
+	assert.Equal(t, "https://keycloak.example.com/realms/icos-dev", endpoints.Issuer)
+	assert.Equal(t, "https://keycloak.example.com/realms/icos-dev/protocol/openid-connect/token", endpoints.TokenEndpoint)
+}
+
+func TestProvider(t *testing.T) {
+	t.Run("should cache the document until the refresh interval", func(t *testing.T) {
+		server, requests := issuer(t, ok)
+		provider := NewProvider(server.URL, time.Hour)
+
+		provider.Endpoints(context.Background())
+		endpoints, err := provider.Endpoints(context.Background())
+
+		assert.NoError(t, err)
+		assert.Equal(t, server.URL+"/oauth2/token", endpoints.TokenEndpoint)
+		assert.Equal(t, int32(1), requests.Load())
+	})
+
+	t.Run("should keep the cached document when the refresh fails", func(t *testing.T) {
+		var status atomic.Int32
+		status.Store(http.StatusOK)
+		server, requests := issuer(t, func() int { return int(status.Load()) })
+		provider := NewProvider(server.URL, time.Millisecond)
+
+		_, err := provider.Endpoints(context.Background())
+		assert.NoError(t, err)
+		status.Store(http.StatusServiceUnavailable)
+		time.Sleep(5 * time.Millisecond)
+		endpoints, err := provider.Endpoints(context.Background())
+
+		assert.NoError(t, err)
+		assert.Equal(t, server.URL+"/oauth2/token", endpoints.TokenEndpoint)
+		assert.Equal(t, int32(2), requests.Load())
+	})
+
+	t.Run("should return the error of the first fetch", func(t *testing.T) {
+		server, _ := issuer(t, func() int { return http.StatusServiceUnavailable })
+		provider := NewProvider(server.URL, time.Hour)
+
+		_, err := provider.Endpoints(context.Background())
+
+		assert.ErrorContains(t, err, "503")
+	})
+}
@@ -0,0 +1,207 @@
+/*
+  OCM-DESCRIPTOR-SIDECAR
+  Copyright © 2022-2024 EVIDEN
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+  This work has received funding from the European Union's HORIZON research
+  and innovation programme under grant agreement No. 101070177.
+*/
+
+// Package redact masks credentials in the HTTP dumps and log records
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+// Mask replaces every redacted value
+const Mask = "[REDACTED]"
+
+var (
+	defaultHeaders    = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+	defaultFormFields = []string{"client_secret", "client_assertion", "password", "refresh_token", "access_token", "code"}
+	defaultJSONKeys   = []string{"access_token", "refresh_token", "id_token", "client_secret", "client_assertion", "password"}
+)
+
+var defaultRedactor atomic.Pointer[Redactor]
+
+func init() {
+	Configure(nil, nil, nil)
+}
+
+// Default returns the redactor used by the logs
+func Default() *Redactor {
+	return defaultRedactor.Load()
+}
+
+// Configure replaces the default redactor with one masking the given names on top
+// of the default ones
+func Configure(headers, formFields, jsonKeys []string) {
+	defaultRedactor.Store(New(
+		append(defaultHeaders[:len(defaultHeaders):len(defaultHeaders)], headers...),
+		append(defaultFormFields[:len(defaultFormFields):len(defaultFormFields)], formFields...),
+		append(defaultJSONKeys[:len(defaultJSONKeys):len(defaultJSONKeys)], jsonKeys...),
+	))
+}
+
+// Redactor masks the values of headers, form fields and JSON keys, matched ignoring case
+type Redactor struct {
+	headers    map[string]bool
+	formFields map[string]bool
+	jsonKeys   map[string]bool
+}
+
+// New creates a redactor masking the given names
+func New(headers, formFields, jsonKeys []string) *Redactor {
+	return &Redactor{headers: set(headers), formFields: set(formFields), jsonKeys: set(jsonKeys)}
+}
+
+// IsSecret reports whether a value named key is masked, whatever it is a header,
+// a form field or a JSON key
+func (r *Redactor) IsSecret(key string) bool {
+	key = strings.ToLower(key)
+	return r.headers[key] || r.formFields[key] || r.jsonKeys[key]
+}
+
+// Header returns a copy of the header with the secret values masked
+func (r *Redactor) Header(header http.Header) http.Header {
+	masked := header.Clone()
+	for name := range masked {
+		if r.headers[strings.ToLower(name)] {
+			masked[name] = []string{Mask}
+		}
+	}
+	return masked
+}
+
+// Form returns the encoded form with the secret fields masked
+func (r *Redactor) Form(values url.Values) string {
+	masked := url.Values{}
+	for name, v := range values {
+		if r.formFields[strings.ToLower(name)] {
+			v = []string{Mask}
+		}
+		masked[name] = v
+	}
+	return masked.Encode()
+}
+
+// JSON returns the document with the values of the secret keys masked at any depth
+func (r *Redactor) JSON(b []byte) ([]byte, error) {
+	var doc any
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(r.maskJSON(doc))
+}
+
+func (r *Redactor) maskJSON(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if r.jsonKeys[strings.ToLower(key)] {
+				v[key] = Mask
+			} else {
+				v[key] = r.maskJSON(value)
+			}
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = r.maskJSON(value)
+		}
+	}
+	return v
+}
+
+// Body masks a form or JSON body according to its content type. Other bodies are
+// returned as they are, and a form or JSON body that cannot be parsed is fully masked.
+func (r *Redactor) Body(contentType string, b []byte) []byte {
+	if len(b) == 0 {
+		return b
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		values, err := url.ParseQuery(string(b))
+		if err != nil {
+			return []byte(Mask)
+		}
+		return []byte(r.Form(values))
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		masked, err := r.JSON(b)
+		if err != nil {
+			return []byte(Mask)
+		}
+		return masked
+	}
+	return b
+}
+
+// DumpRequest returns the request in its wire representation with the secrets masked.
+// The body is only included when body is true, and stays readable by the caller.
+func (r *Redactor) DumpRequest(req *http.Request, body bool) (string, error) {
+	clone := req.Clone(req.Context())
+	clone.Header = r.Header(req.Header)
+	dump, err := httputil.DumpRequest(clone, false)
+	if err != nil || !body {
+		return string(dump), err
+	}
+	b, err := readBody(&req.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(dump) + string(r.Body(req.Header.Get("Content-Type"), b)), nil
+}
+
+// DumpResponse returns the response like DumpRequest
+func (r *Redactor) DumpResponse(resp *http.Response, body bool) (string, error) {
+	clone := *resp
+	clone.Header = r.Header(resp.Header)
+	dump, err := httputil.DumpResponse(&clone, false)
+	if err != nil || !body {
+		return string(dump), err
+	}
+	b, err := readBody(&resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(dump) + string(r.Body(resp.Header.Get("Content-Type"), b)), nil
+}
+
+// readBody reads the body and replaces it with a reader over the same bytes
+func readBody(body *io.ReadCloser) ([]byte, error) {
+	if *body == nil || *body == http.NoBody {
+		return nil, nil
+	}
+	b, err := io.ReadAll(*body)
+	(*body).Close()
+	*body = io.NopCloser(bytes.NewReader(b))
+	return b, err
+}
+
+func set(names []string) map[string]bool {
+	s := make(map[string]bool, len(names))
+	for _, name := range names {
+		s[strings.ToLower(name)] = true
+	}
+	return s
+}
@@ -0,0 +1,79 @@
+package redact
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactor(t *testing.T) {
+	redactor := New(defaultHeaders, defaultFormFields, append(defaultJSONKeys, "api_key"))
+
+	t.Run("should mask the secret headers", func(t *testing.T) {
+		header := http.Header{"Authorization": {"Bearer secret"}, "Accept": {"*/*"}}
+
+		masked := redactor.Header(header)
+
+		assert.Equal(t, Mask, masked.Get("Authorization"))
+		assert.Equal(t, "*/*", masked.Get("Accept"))
+		assert.Equal(t, "Bearer secret", header.Get("Authorization"))
+	})
+
+	t.Run("should mask the secret form fields", func(t *testing.T) {
+		form := url.Values{"client_id": {"sidecar"}, "client_secret": {"secret"}}
+
+		assert.Equal(t, "client_id=sidecar&client_secret=%5BREDACTED%5D", redactor.Form(form))
+	})
+
+	t.Run("should mask the secret JSON keys at any depth", func(t *testing.T) {
+		masked, err := redactor.JSON([]byte(`{"access_token":"a","nested":[{"API_KEY":"b","name":"c"}]}`))
+
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"access_token":"[REDACTED]","nested":[{"API_KEY":"[REDACTED]","name":"c"}]}`, string(masked))
+	})
+
+	t.Run("should fully mask a body that cannot be parsed", func(t *testing.T) {
+		assert.Equal(t, Mask, string(redactor.Body("application/json", []byte(`{"access_token":`))))
+		assert.Equal(t, "plain", string(redactor.Body("text/plain", []byte("plain"))))
+	})
+
+	t.Run("should dump a request without leaking secrets", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/token", strings.NewReader("client_secret=s3cr3t&grant_type=client_credentials"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer s3cr3t")
+
+		headersOnly, err := redactor.DumpRequest(req, false)
+		assert.NoError(t, err)
+		assert.NotContains(t, headersOnly, "grant_type")
+
+		dump, err := redactor.DumpRequest(req, true)
+		assert.NoError(t, err)
+		assert.NotContains(t, dump, "s3cr3t")
+		assert.Contains(t, dump, "grant_type=client_credentials")
+
+		body, _ := io.ReadAll(req.Body)
+		assert.Equal(t, "client_secret=s3cr3t&grant_type=client_credentials", string(body))
+	})
+
+	t.Run("should dump a response without leaking secrets", func(t *testing.T) {
+		resp := &http.Response{
+			StatusCode: http.StatusOK,
+			ProtoMajor: 1, ProtoMinor: 1,
+			Header: http.Header{"Content-Type": {"application/json"}},
+			Body:   io.NopCloser(strings.NewReader(`{"access_token":"secret","expires_in":300}`)),
+		}
+
+		dump, err := redactor.DumpResponse(resp, true)
+
+		assert.NoError(t, err)
+		assert.NotContains(t, dump, "secret")
+		assert.Contains(t, dump, `"expires_in":300`)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Contains(t, string(body), "secret")
+	})
+}
@@ -0,0 +1,150 @@
+/*
+  OCM-DESCRIPTOR-SIDECAR
+  Copyright © 2022-2024 EVIDEN
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+  This work has received funding from the European Union's HORIZON research
+  and innovation programme under grant agreement No. 101070177.
+*/
+
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Policy describes how many times and how long apart an operation is retried
+type Policy struct {
+	MaxAttempts    int           `yaml:"maxAttempts"`
+	InitialBackoff time.Duration `yaml:"initialBackoff"`
+	MaxBackoff     time.Duration `yaml:"maxBackoff"`
+	Multiplier     float64       `yaml:"multiplier"`
+	// Jitter is the fraction of the backoff, between 0 and 1, that is randomized
+	Jitter float64 `yaml:"jitter"`
+}
+
+// DefaultPolicy returns the policy used when nothing is configured
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxAttempts:    3,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     10 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+	}
+}
+
+// Validate checks that the policy can be used
+func (p Policy) Validate() error {
+	switch {
+	case p.MaxAttempts < 1:
+		return fmt.Errorf("max attempts must be at least 1, got %d", p.MaxAttempts)
+	case p.InitialBackoff < 0 || p.MaxBackoff < p.InitialBackoff:
+		return fmt.Errorf("invalid backoff range %s - %s", p.InitialBackoff, p.MaxBackoff)
+	case p.Multiplier < 1:
+		return fmt.Errorf("multiplier must be at least 1, got %g", p.Multiplier)
+	case p.Jitter < 0 || p.Jitter > 1:
+		return fmt.Errorf("jitter must be between 0 and 1, got %g", p.Jitter)
+	}
+	return nil
+}
+
+// retryableError marks an error as worth another attempt
+type retryableError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// Retryable marks err as retryable. A positive retryAfter is the minimum delay
+// requested by the server before the next attempt.
+func Retryable(err error, retryAfter time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	return &retryableError{err: err, retryAfter: retryAfter}
+}
+
+// IsRetryable reports whether err has been marked as retryable
+func IsRetryable(err error) bool {
+	var re *retryableError
+	return errors.As(err, &re)
+}
+
+// Do calls fn until it succeeds, returns an error not marked as Retryable or the
+// attempts are exhausted. It returns the number of attempts made and the last error,
+// wrapped with the error of ctx when ctx is done while waiting for the next attempt.
+func (p Policy) Do(ctx context.Context, fn func(attempt int) error) (int, error) {
+	attempt := 0
+	for {
+		attempt++
+		err := fn(attempt)
+		var re *retryableError
+		if err == nil || !errors.As(err, &re) || attempt >= p.MaxAttempts {
+			return attempt, err
+		}
+
+		wait := p.Backoff(attempt)
+		if re.retryAfter > wait {
+			if re.retryAfter > p.MaxBackoff {
+				return attempt, fmt.Errorf("retry after %s exceeds max backoff: %w", re.retryAfter, err)
+			}
+			wait = re.retryAfter
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, fmt.Errorf("%w while waiting to retry: %w", ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+}
+
+// Backoff returns the randomized delay to wait after the given attempt
+func (p Policy) Backoff(attempt int) time.Duration {
+	backoff := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	if backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	backoff -= backoff * p.Jitter * rand.Float64()
+	return time.Duration(backoff)
+}
+
+// ParseRetryAfter reads a Retry-After header given either in seconds or as an HTTP date
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := date.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
@@ -0,0 +1,122 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testPolicy() Policy {
+	return Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond, Multiplier: 2}
+}
+
+func TestDo(t *testing.T) {
+	t.Run("should retry retryable errors until success", func(t *testing.T) {
+		attempts, err := testPolicy().Do(context.Background(), func(attempt int) error {
+			if attempt < 3 {
+				return Retryable(errors.New("unavailable"), 0)
+			}
+			return nil
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("should stop on non retryable errors", func(t *testing.T) {
+		attempts, err := testPolicy().Do(context.Background(), func(attempt int) error {
+			return errors.New("bad request")
+		})
+
+		assert.Error(t, err)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("should give up after max attempts", func(t *testing.T) {
+		attempts, err := testPolicy().Do(context.Background(), func(attempt int) error {
+			return Retryable(errors.New("unavailable"), 0)
+		})
+
+		assert.True(t, IsRetryable(err))
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("should not wait longer than max backoff for retry after", func(t *testing.T) {
+		attempts, err := testPolicy().Do(context.Background(), func(attempt int) error {
+			return Retryable(errors.New("too many requests"), time.Minute)
+		})
+
+		assert.Error(t, err)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("should stop when context is cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		policy := testPolicy()
+		policy.InitialBackoff = time.Hour
+		policy.MaxBackoff = time.Hour
+
+		attempts, err := policy.Do(ctx, func(attempt int) error {
+			return Retryable(errors.New("unavailable"), 0)
+		})
+
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("should return the cancellation when cancelled while backing off", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		policy := testPolicy()
+		policy.InitialBackoff = time.Hour
+		policy.MaxBackoff = time.Hour
+		unavailable := errors.New("unavailable")
+
+		attempts, err := policy.Do(ctx, func(attempt int) error {
+			time.AfterFunc(10*time.Millisecond, cancel)
+			return Retryable(unavailable, 0)
+		})
+
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.ErrorIs(t, err, unavailable)
+		assert.Equal(t, 1, attempts)
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	policy := Policy{MaxAttempts: 5, InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2, Jitter: 0.5}
+
+	for attempt, max := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 5: time.Second} {
+		backoff := policy.Backoff(attempt)
+		assert.LessOrEqual(t, backoff, max)
+		assert.GreaterOrEqual(t, backoff, max/2)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	t.Run("should parse seconds", func(t *testing.T) {
+		d, ok := ParseRetryAfter("120", now)
+
+		assert.True(t, ok)
+		assert.Equal(t, 2*time.Minute, d)
+	})
+
+	t.Run("should parse http date", func(t *testing.T) {
+		d, ok := ParseRetryAfter(now.Add(30*time.Second).Format(http.TimeFormat), now)
+
+		assert.True(t, ok)
+		assert.Equal(t, 30*time.Second, d)
+	})
+
+	t.Run("should reject invalid values", func(t *testing.T) {
+		_, ok := ParseRetryAfter("soon", now)
+
+		assert.False(t, ok)
+	})
+}
@@ -0,0 +1,76 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func inMemoryTracing(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return exporter
+}
+
+func TestSetup(t *testing.T) {
+	t.Run("should be a no-op without endpoint", func(t *testing.T) {
+		shutdown, err := Setup(context.Background(), Config{})
+		assert.NoError(t, err)
+		assert.NoError(t, shutdown(context.Background()))
+	})
+}
+
+func TestClientSpan(t *testing.T) {
+	t.Run("should propagate traceparent and record a child span", func(t *testing.T) {
+		exporter := inMemoryTracing(t)
+		var traceparent string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			traceparent = r.Header.Get("traceparent")
+		}))
+		defer server.Close()
+
+		ctx, parent := Tracer().Start(context.Background(), "schedule execute")
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/execute", nil)
+		req, span := StartClientSpan(req, "GET /execute")
+		resp, err := http.DefaultClient.Do(req)
+		EndClientSpan(span, resp, err)
+		parent.End()
+
+		assert.NoError(t, err)
+		spans := exporter.GetSpans()
+		assert.Len(t, spans, 2)
+		assert.Equal(t, "GET /execute", spans[0].Name)
+		assert.Equal(t, parent.SpanContext().SpanID(), spans[0].Parent.SpanID())
+		assert.Contains(t, traceparent, spans[0].SpanContext.TraceID().String())
+		assert.Contains(t, traceparent, spans[0].SpanContext.SpanID().String())
+	})
+
+	t.Run("should mark failed responses as errors", func(t *testing.T) {
+		exporter := inMemoryTracing(t)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/resource/sync", nil)
+		req, span := StartClientSpan(req, "GET /resource/sync")
+		resp, err := http.DefaultClient.Do(req)
+		EndClientSpan(span, resp, err)
+
+		spans := exporter.GetSpans()
+		assert.Len(t, spans, 1)
+		assert.Equal(t, codes.Error, spans[0].Status.Code)
+	})
+}
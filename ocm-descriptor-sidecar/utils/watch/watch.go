@@ -0,0 +1,77 @@
+/*
+  OCM-DESCRIPTOR-SIDECAR
+  Copyright © 2022-2024 EVIDEN
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+  This work has received funding from the European Union's HORIZON research
+  and innovation programme under grant agreement No. 101070177.
+*/
+
+// Package watch detects changes to files by polling their content, which also works for
+// the Kubernetes ConfigMap and Secret volumes that are updated by swapping symlinks
+package watch
+
+import (
+	"context"
+	"crypto/sha256"
+	"maps"
+	"os"
+	"time"
+)
+
+// Files checks the files returned by paths every interval and sends on the returned
+// channel when the content of any of them changed, until ctx is cancelled. A file that
+// appears or disappears counts as a change.
+func Files(ctx context.Context, interval time.Duration, paths func() []string) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+	// taken before returning so that the changes made right after are noticed
+	sums := checksums(paths())
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				current := checksums(paths())
+				if maps.Equal(sums, current) {
+					continue
+				}
+				sums = current
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return changes
+}
+
+// checksums returns the checksum of every file, the zero checksum standing for a
+// file that cannot be read
+func checksums(paths []string) map[string][sha256.Size]byte {
+	sums := make(map[string][sha256.Size]byte, len(paths))
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			sums[path] = [sha256.Size]byte{}
+			continue
+		}
+		sums[path] = sha256.Sum256(b)
+	}
+	return sums
+}
@@ -0,0 +1,50 @@
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFiles(t *testing.T) {
+	t.Run("should notify when the content of a file changes", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		assert.NoError(t, os.WriteFile(path, []byte("a"), 0o600))
+
+		changes := Files(ctx, 10*time.Millisecond, func() []string { return []string{path} })
+		select {
+		case <-changes:
+			t.Fatal("unexpected change")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		assert.NoError(t, os.WriteFile(path, []byte("b"), 0o600))
+		select {
+		case <-changes:
+		case <-time.After(time.Second):
+			t.Fatal("change not detected")
+		}
+	})
+
+	t.Run("should notify when a file disappears", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		path := filepath.Join(t.TempDir(), "secret")
+		assert.NoError(t, os.WriteFile(path, []byte("s3cr3t"), 0o600))
+
+		changes := Files(ctx, 10*time.Millisecond, func() []string { return []string{path} })
+		assert.NoError(t, os.Remove(path))
+
+		select {
+		case <-changes:
+		case <-time.After(time.Second):
+			t.Fatal("removal not detected")
+		}
+	})
+}